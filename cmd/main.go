@@ -2,13 +2,8 @@ package main
 
 import (
 	"bchain/internal/cli"
-	database "bchain/internal/db"
 )
 
 func main() {
-	db, err := database.NewDb("./blocks.db")
-	if err != nil {
-		panic(db)
-	}
-	cli.NewCli(db).Run()
+	cli.NewCli().Run()
 }
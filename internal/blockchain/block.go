@@ -3,95 +3,158 @@ package blockchain
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
 	"math/big"
-	"strconv"
 	"time"
 )
 
 type Block struct {
-	Version      uint32
-	Timestamp    int64
+	BlockHeader
 	Transactions []*Transaction
-	Hash         []byte
-	PrevHash     []byte
-	Nbits        uint8
-	Nonce        uint64
-	Height       uint64
 }
 
-func NewBlock(transactions []*Transaction, prevHash []byte, height uint64) *Block {
+// everything proof of work commits to, transactions are linked by the merkle root
+// of their full hashes, so scriptSigs are committed too.
+// header can be stored, relayed and validated without transactions
+type BlockHeader struct {
+	Version    uint32
+	Timestamp  int64
+	PrevHash   []byte
+	MerkleRoot []byte
+	Nbits      uint32
+	Nonce      uint64
+	Height     uint64
+	// hash of the serialized header, it is not serialized itself
+	Hash []byte
+}
+
+// size of serialized header: version, timestamp, prev hash, merkle root, nbits, nonce and height
+const HeaderSize = 4 + 8 + 32 + 32 + 4 + 8 + 8
+
+// mines block with local time as timestamp, it is raised to minTimestamp if the clock is behind
+func NewBlock(transactions []*Transaction, prevHash []byte, height uint64, nbits uint32, minTimestamp int64) *Block {
+	timestamp := time.Now().Unix()
+	if timestamp < minTimestamp {
+		timestamp = minTimestamp
+	}
 	block := &Block{
-		Version:      BlockchainVersion,
-		Timestamp:    time.Now().Unix(),
+		BlockHeader: BlockHeader{
+			Version:   BlockchainVersion,
+			Timestamp: timestamp,
+			Hash:      []byte{},
+			PrevHash:  prevHash,
+			Nbits:     nbits,
+			Height:    height,
+		},
 		Transactions: transactions,
-		Hash:         []byte{},
-		PrevHash:     prevHash,
-		Nbits:        16,
-		Height:       height,
 	}
-	pow := NewPoW(block)
+	block.MerkleRoot = block.HashTransactions()
+	pow := NewPoW(&block.BlockHeader)
 	pow.RunParallel()
 	return block
 }
 
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+func (b *Block) Header() BlockHeader {
+	return b.BlockHeader
 }
 
-func (b *Block) GetData() []byte {
-	return b.getDataNonce(b.Nonce)
+// returns serialized header, block hash is computed from it
+func (h *BlockHeader) GetData() []byte {
+	return h.getDataNonce(h.Nonce)
 }
 
-func (b *Block) getDataNonce(nonce uint64) []byte {
-	data := bytes.Join(
-		[][]byte{
-			[]byte(strconv.FormatUint(uint64(b.Version), 16)),
-			[]byte(strconv.FormatUint(uint64(b.Timestamp), 16)),
-			b.HashTransactions(),
-			b.PrevHash,
-			[]byte(strconv.FormatUint(uint64(b.Nbits), 16)),
-			[]byte(strconv.FormatUint(uint64(nonce), 16)),
-			[]byte(strconv.FormatUint(uint64(b.Height), 16)),
-		},
-		[]byte{},
-	)
-	return data
+func (h *BlockHeader) getDataNonce(nonce uint64) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, HeaderSize))
+	writeUint32(buf, h.Version)
+	writeUint(buf, uint64(h.Timestamp))
+	writeHash(buf, h.PrevHash)
+	writeHash(buf, h.MerkleRoot)
+	writeUint32(buf, h.Nbits)
+	writeUint(buf, nonce)
+	writeUint(buf, h.Height)
+	return buf.Bytes()
+}
+
+func (h *BlockHeader) computeHash() []byte {
+	hash := sha256.Sum256(h.GetData())
+	return hash[:]
+}
+
+// checks that hash is computed from header fields and satisfies the target
+func (h *BlockHeader) Validate() bool {
+	hash := h.computeHash()
+	if !bytes.Equal(hash, h.Hash) {
+		return false
+	}
+	var hashNum big.Int
+	hashNum.SetBytes(hash)
+	return hashNum.Cmp(getTarget(h.Nbits)) == -1
+}
+
+func (h *BlockHeader) Serialize() ([]byte, error) {
+	return h.GetData(), nil
+}
+
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	d := &decoder{data: data}
+	header := d.header()
+	err := d.finish()
+	if err != nil {
+		return nil, err
+	}
+	return &header, nil
 }
 
 func (b *Block) HashTransactions() []byte {
+	return b.merkleTree().Root.Data
+}
+
+// leaves are hashes of whole transactions, not their ids which don't cover scriptSigs
+func (b *Block) merkleTree() *MerkleTree {
 	var txHashes [][]byte
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, tx.ID)
+		hash, err := tx.Hash()
+		if err != nil {
+			panic(err)
+		}
+		txHashes = append(txHashes, hash)
 	}
-	merkleTree := NewMerkleTree(txHashes)
-	return merkleTree.Root.Data
+	return NewMerkleTree(txHashes)
 }
 
-func (b *Block) Validate() bool {
-	data := b.GetData()
-	hash := sha256.Sum256(data)
-	var hashNum big.Int
-	hashNum.SetBytes(hash[:])
-	return hashNum.Cmp(getTarget(b.Nbits)) == -1
+// returns proof that the transaction is in the block
+func (b *Block) MerkleProof(tx *Transaction) (*MerkleProof, error) {
+	hash, err := tx.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return b.merkleTree().Proof(hash)
 }
 
-func (b *Block) Serialize() ([]byte, error) {
-	result := bytes.Buffer{}
-	encoder := gob.NewEncoder(&result)
-	err := encoder.Encode(b)
+// checks proof that the transaction with its scriptSigs is in the block with the header
+func (h *BlockHeader) HasTransaction(tx *Transaction, proof *MerkleProof) bool {
+	hash, err := tx.Hash()
 	if err != nil {
-		return []byte{}, err
+		return false
 	}
-	return result.Bytes(), nil
+	return proof.Verify(hash, h.MerkleRoot)
+}
+
+// checks that hash is computed from block header and satisfies the target
+func (b *Block) Validate() bool {
+	return b.BlockHeader.Validate()
+}
+
+func (b *Block) Serialize() ([]byte, error) {
+	return serialize(b.encode), nil
 }
 
 func DeserializeBlock(data []byte) (*Block, error) {
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	block := new(Block)
-	err := decoder.Decode(block)
+	d := &decoder{data: data}
+	d.readVersion()
+	block := d.block()
+	err := d.finish()
 	if err != nil {
-		return block, err
+		return nil, err
 	}
 	return block, nil
 }
@@ -3,18 +3,32 @@ package blockchain
 import (
 	database "bchain/internal/db"
 	"bytes"
-	"crypto/ecdsa"
 	"errors"
+	"math/big"
+	"sync"
 )
 
-const WalletFile = "./wallets.dat"
-const BlockchainVersion = 1
+const BlockchainVersion = 6
+
+// how many consecutive hashes are placed into a block locator before the step starts doubling
+const locatorDenseLen = 10
 
 type Blockchain struct {
 	// last block hash
 	tip     []byte
 	db      *database.DB
 	utxoset *UTXOset
+	mempool *Mempool
+	orphans *OrphanPool
+	params  *ChainParams
+	// hash of the genesis block of the network
+	genesis []byte
+	// guards writes to the chain
+	mu sync.Mutex
+	// guards tip, it is written under mu and read by iterators which don't take mu
+	tipMu sync.RWMutex
+	// hashes of stored blocks which failed validation during reorganization and of their descendants
+	invalid map[string]bool
 }
 
 type BlockchainIterator struct {
@@ -23,84 +37,494 @@ type BlockchainIterator struct {
 	db           *database.DB
 }
 
-func NewBlockchain(db *database.DB, address string) (*Blockchain, error) {
+// opens the chain stored in db, genesis block of the network is stored if db is empty.
+// chain with another genesis block can't be opened
+func NewBlockchain(db *database.DB, params *ChainParams) (*Blockchain, error) {
+	genesis, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
 	last, err := db.GetLast()
 	if err != nil {
 		return nil, err
 	}
 	if len(last) > 0 {
-		bc := &Blockchain{tip: last, db: db}
-		bc.utxoset = NewUTXOset(bc)
-		return bc, nil
-	}
-	if address == "" {
-		bc := &Blockchain{tip: []byte{}, db: db}
-		bc.utxoset = NewUTXOset(bc)
+		bc := newBlockchain(db, last, params, genesis.Hash)
+		stored, err := bc.getGenesisHash()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(stored, genesis.Hash) {
+			return nil, errors.New("STORED GENESIS DOES NOT MATCH CONFIGURED ONE")
+		}
 		return bc, nil
 	}
-	coinbaseTX, err := NewCoinbaseTX(address, address)
+	genesisSerialized, err := genesis.Serialize()
 	if err != nil {
 		return nil, err
 	}
-	block := NewGenesisBlock(coinbaseTX)
-	genesisSerialized, err := block.Serialize()
+	err = db.AddBlock(genesis.Hash, genesisSerialized)
 	if err != nil {
 		return nil, err
 	}
-	err = db.UpdateLast(block.Hash)
+	err = db.UpdateGenesis(genesis.Hash)
 	if err != nil {
 		return nil, err
 	}
-	err = db.AddBlock(block.Hash, genesisSerialized)
+	err = db.UpdateLast(genesis.Hash)
 	if err != nil {
 		return nil, err
 	}
-	bc := &Blockchain{tip: block.Hash, db: db}
-	bc.utxoset = NewUTXOset(bc)
+	bc := newBlockchain(db, genesis.Hash, params, genesis.Hash)
 	bc.utxoset.Reindex()
 	return bc, nil
 }
 
-func (bc *Blockchain) MineBlock(transactions []*Transaction) error {
+// returns stored genesis hash, chains stored before it was saved are walked back to genesis
+func (bc *Blockchain) getGenesisHash() ([]byte, error) {
+	hash, err := bc.db.GetGenesis()
+	if err != nil || len(hash) > 0 {
+		return hash, err
+	}
+	bci := bc.Iterator()
+	for bci.Next() {
+		hash = bci.Block().Hash
+	}
+	err = bc.db.UpdateGenesis(hash)
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+func newBlockchain(db *database.DB, tip []byte, params *ChainParams, genesis []byte) *Blockchain {
+	bc := &Blockchain{tip: tip, db: db, params: params, genesis: genesis, invalid: map[string]bool{}}
+	bc.utxoset = NewUTXOset(bc)
+	bc.mempool = NewMempool(bc)
+	bc.orphans = NewOrphanPool()
+	return bc
+}
+
+func (bc *Blockchain) Mempool() *Mempool {
+	return bc.mempool
+}
+
+func (bc *Blockchain) Orphans() *OrphanPool {
+	return bc.orphans
+}
+
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.params
+}
+
+// mines block with the transactions on top of the tip, its coinbase pays subsidy and fees to minerAddress.
+// chain is locked while the block is built and connected but not during proof of work
+func (bc *Blockchain) MineBlock(minerAddress string, transactions []*Transaction) error {
+	_, err := bc.mine(minerAddress, transactions, false)
+	return err
+}
+
+// mines block with mempool transactions which are valid on top of the tip, the other ones are
+// evicted from mempool. returns nil if there is no transaction to mine
+func (bc *Blockchain) MineMempool(minerAddress string) (*Block, error) {
+	return bc.mine(minerAddress, bc.mempool.Transactions(), true)
+}
+
+var ErrTipChanged = errors.New("CHAIN TIP CHANGED WHILE MINING")
+
+func (bc *Blockchain) mine(minerAddress string, transactions []*Transaction, evict bool) (*Block, error) {
+	bc.mu.Lock()
+	template, err := bc.newBlockTemplate(minerAddress, transactions, evict)
+	bc.mu.Unlock()
+	if err != nil || template == nil {
+		return nil, err
+	}
+	block := NewBlock(template.transactions, template.prevHash, template.height, template.nbits, template.minTimestamp)
+	err = bc.AddBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(bc.getTip(), block.Hash) {
+		return nil, ErrTipChanged
+	}
+	return block, nil
+}
+
+// everything needed to mine block on top of the tip
+type blockTemplate struct {
+	transactions []*Transaction
+	prevHash     []byte
+	height       uint64
+	nbits        uint32
+	minTimestamp int64
+}
+
+// builds template on top of the tip, must be called with mu held. if evict is set invalid
+// transactions are skipped and evicted from mempool, nil is returned if none is left.
+// otherwise the first invalid transaction fails the template
+func (bc *Blockchain) newBlockTemplate(minerAddress string, transactions []*Transaction, evict bool) (*blockTemplate, error) {
 	lastHash, err := bc.db.GetLast()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	bc.setTip(lastHash)
+	lastBlock, err := bc.GetBlock(lastHash)
+	if err != nil {
+		return nil, err
 	}
+	height := lastBlock.Height + 1
+	c, err := newTxChecker(bc, height)
+	if err != nil {
+		return nil, err
+	}
+	var fees int64
+	var selected []*Transaction
 	for _, tx := range transactions {
-		if b, err := bc.VerifyTransaction(tx); err != nil || !b {
-			return errors.New("INVALID TRANSACTION")
+		fee, err := c.check(tx)
+		if err == nil {
+			var ok bool
+			fees, ok = addMoney(fees, fee)
+			if ok {
+				selected = append(selected, tx)
+				continue
+			}
+			err = ErrBadValue
+		}
+		if !evict {
+			return nil, err
 		}
+		bc.mempool.evict(tx.ID)
 	}
-	lastBlockSer, err := bc.db.GetBlock(lastHash)
+	if evict && len(selected) == 0 {
+		return nil, nil
+	}
+	coinbaseTx, err := NewCoinbaseTX(minerAddress, "", height, bc.params.Subsidy(height)+fees)
+	if err != nil {
+		return nil, err
+	}
+	lastHeader := lastBlock.Header()
+	nbits, err := bc.expectedBits(&lastHeader, nil)
+	if err != nil {
+		return nil, err
+	}
+	medianTime, err := medianTimePast(&lastHeader, func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &blockTemplate{
+		transactions: append([]*Transaction{coinbaseTx}, selected...),
+		prevHash:     lastHash,
+		height:       height,
+		nbits:        nbits,
+		minTimestamp: medianTime + 1,
+	}, nil
+}
+
+// validates block received from other node and stores it.
+// block becomes the tip if it extends the chain or if its branch has more work than the current chain
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	err := bc.addBlock(block)
 	if err != nil {
 		return err
 	}
-	lastBlock, err := DeserializeBlock(lastBlockSer)
+	bc.connectOrphans(block.Hash)
+	return nil
+}
+
+// adds orphans waiting for the block, then orphans waiting for them and so on
+func (bc *Blockchain) connectOrphans(hash []byte) {
+	queue := [][]byte{hash}
+	for len(queue) > 0 {
+		children := bc.orphans.takeChildren(queue[0])
+		queue = queue[1:]
+		for _, child := range children {
+			if bc.addBlock(child) == nil {
+				queue = append(queue, child.Hash)
+			}
+		}
+	}
+}
+
+// blocks with unknown parent are kept in orphan pool and ErrOrphanBlock is returned
+func (bc *Blockchain) addBlock(block *Block) error {
+	if bc.invalid[string(block.Hash)] {
+		return ErrInvalidBlock
+	}
+	if bc.HasBlock(block.Hash) {
+		return nil
+	}
+	lastHash, err := bc.db.GetLast()
 	if err != nil {
 		return err
 	}
-	newBlock := NewBlock(transactions, lastHash, lastBlock.Height+1)
-	err = bc.db.UpdateLast(newBlock.Hash)
+	bc.setTip(lastHash)
+	err = ValidateBlock(bc, block)
+	if errors.Is(err, ErrOrphanBlock) {
+		bc.orphans.Add(block)
+		return err
+	}
 	if err != nil {
 		return err
 	}
-	newSerialized, err := newBlock.Serialize()
+	// checked after proof of work, so the set can't be filled with cheap blocks
+	if bc.invalid[string(block.PrevHash)] {
+		bc.invalid[string(block.Hash)] = true
+		return ErrInvalidParent
+	}
+	if bytes.Equal(block.PrevHash, lastHash) {
+		return bc.connectBlock(block)
+	}
+	// side branch, its transactions are verified when it becomes the best chain
+	err = bc.storeBlock(block)
 	if err != nil {
 		return err
 	}
-	err = bc.db.AddBlock(newBlock.Hash, newSerialized)
+	work, err := bc.getChainWork(block.Hash)
 	if err != nil {
 		return err
 	}
-	err = bc.utxoset.UpdateWithBlock(newBlock)
+	tipWork, err := bc.getChainWork(lastHash)
 	if err != nil {
 		return err
 	}
+	if work.Cmp(tipWork) <= 0 {
+		return nil
+	}
+	return bc.reorganize(lastHash, block)
+}
+
+func (bc *Blockchain) storeBlock(block *Block) error {
+	serialized, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+	err = bc.db.AddBlock(block.Hash, serialized)
+	if err != nil {
+		return err
+	}
+	_, err = bc.getChainWork(block.Hash)
+	return err
+}
+
+func (bc *Blockchain) connectBlock(block *Block) error {
+	err := bc.storeBlock(block)
+	if err != nil {
+		return err
+	}
+	err = bc.db.UpdateLast(block.Hash)
+	if err != nil {
+		return err
+	}
+	bc.setTip(block.Hash)
+	err = bc.utxoset.UpdateWithBlock(block)
+	if err != nil {
+		return err
+	}
+	bc.mempool.RemoveBlockTransactions(block)
+	return nil
+}
+
+func (bc *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	serialized, err := bc.db.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized) == 0 {
+		return nil, errors.New("BLOCK IS NOT FOUND")
+	}
+	return DeserializeBlock(serialized)
+}
+
+func (bc *Blockchain) HasBlock(hash []byte) bool {
+	serialized, err := bc.db.GetBlock(hash)
+	return err == nil && len(serialized) > 0
+}
+
+func (bc *Blockchain) GetLastHash() ([]byte, error) {
+	return bc.db.GetLast()
+}
+
+func (bc *Blockchain) IsEmpty() bool {
+	last, err := bc.db.GetLast()
+	return err == nil && len(last) == 0
+}
+
+// returns hashes from the tip back to genesis with exponentially growing gaps
+func (bc *Blockchain) GetBlockLocator() [][]byte {
+	locator := [][]byte{}
+	var genesis []byte
+	step, next := 1, 0
+	bci := bc.Iterator()
+	for i := 0; bci.Next(); i++ {
+		block := bci.Block()
+		if i == next {
+			locator = append(locator, block.Hash)
+			if len(locator) >= locatorDenseLen {
+				step *= 2
+			}
+			next += step
+		}
+		genesis = block.Hash
+	}
+	if len(locator) > 0 && !bytes.Equal(locator[len(locator)-1], genesis) {
+		locator = append(locator, genesis)
+	}
+	return locator
+}
+
+// returns up to limit hashes of the blocks following the first locator hash found in the chain.
+// stops after stopHash if it is not empty
+func (bc *Blockchain) GetBlockHashesAfter(locator [][]byte, stopHash []byte, limit int) [][]byte {
+	hashes := [][]byte{}
+	bci := bc.Iterator()
+	for bci.Next() {
+		hash := bci.Block().Hash
+		if containsHash(locator, hash) {
+			break
+		}
+		hashes = append(hashes, hash)
+	}
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	for i, hash := range hashes {
+		if len(stopHash) != 0 && bytes.Equal(hash, stopHash) {
+			hashes = hashes[:i+1]
+			break
+		}
+	}
+	if len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return hashes
+}
+
+func (bc *Blockchain) GetHeadersAfter(locator [][]byte, stopHash []byte, limit int) ([]BlockHeader, error) {
+	headers := []BlockHeader{}
+	for _, hash := range bc.GetBlockHashesAfter(locator, stopHash, limit) {
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, block.Header())
+	}
+	return headers, nil
+}
+
+// checks proof of work, timestamps and links of consecutive headers.
+// if prev is nil the first header must follow a stored block or be a genesis of empty chain
+func (bc *Blockchain) ValidateHeaders(prev *BlockHeader, headers []BlockHeader) error {
+	// validated headers, they are needed to compute targets of the following ones
+	pending := map[string]*BlockHeader{}
+	for i := range headers {
+		header := &headers[i]
+		err := checkTarget(bc.params, header.Nbits)
+		if err != nil {
+			return err
+		}
+		if !header.Validate() {
+			return errors.New("INVALID HEADER HASH")
+		}
+		if prev == nil {
+			if len(header.PrevHash) == 0 {
+				if !bytes.Equal(header.Hash, bc.genesis) {
+					return errors.New("UNEXPECTED GENESIS HEADER")
+				}
+				pending[string(header.Hash)] = header
+				prev = header
+				continue
+			}
+			parent, err := bc.GetBlock(header.PrevHash)
+			if err != nil {
+				return errors.New("UNKNOWN HEADER PARENT")
+			}
+			parentHeader := parent.Header()
+			prev = &parentHeader
+		}
+		if !bytes.Equal(header.PrevHash, prev.Hash) {
+			return errors.New("HEADERS ARE NOT LINKED")
+		}
+		if header.Height != prev.Height+1 {
+			return errors.New("INVALID HEADER HEIGHT")
+		}
+		nbits, err := bc.expectedBits(prev, pending)
+		if err != nil {
+			return err
+		}
+		if header.Nbits != nbits {
+			return errors.New("INVALID HEADER TARGET")
+		}
+		err = checkTimestamp(header, prev, func(hash []byte) (*BlockHeader, error) {
+			return bc.getHeader(hash, pending)
+		})
+		if err != nil {
+			return err
+		}
+		pending[string(header.Hash)] = header
+		prev = header
+	}
 	return nil
 }
 
+// checks if the chain of validated headers following a stored block has more work than the
+// current chain, so bodies of a chain which can't become the best one are not downloaded
+func (bc *Blockchain) HasMoreWork(headers []BlockHeader) (bool, error) {
+	if len(headers) == 0 {
+		return false, nil
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	work := big.NewInt(0)
+	if len(headers[0].PrevHash) > 0 {
+		parentWork, err := bc.getChainWork(headers[0].PrevHash)
+		if err != nil {
+			return false, err
+		}
+		work.Set(parentWork)
+	}
+	for i := range headers {
+		work.Add(work, blockWork(headers[i].Nbits))
+	}
+	tipWork, err := bc.getChainWork(bc.getTip())
+	if err != nil {
+		return false, err
+	}
+	return work.Cmp(tipWork) > 0, nil
+}
+
+func containsHash(hashes [][]byte, hash []byte) bool {
+	for _, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return true
+		}
+	}
+	return false
+}
+
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	return &BlockchainIterator{bc.tip, nil, bc.db}
+	// chain can be extended by another process using the same db
+	last, err := bc.db.GetLast()
+	if err != nil || len(last) == 0 {
+		last = bc.getTip()
+	}
+	return &BlockchainIterator{last, nil, bc.db}
+}
+
+func (bc *Blockchain) getTip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+	return bc.tip
+}
+
+// must be called with mu held
+func (bc *Blockchain) setTip(hash []byte) {
+	bc.tipMu.Lock()
+	defer bc.tipMu.Unlock()
+	bc.tip = hash
 }
 
 func (bci *BlockchainIterator) Next() bool {
@@ -172,9 +596,12 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 						}
 					}
 				}
-				outs := utxo[string(tx.ID)]
-				outs.Outputs = append(outs.Outputs, out)
-				utxo[string(tx.ID)] = outs
+				outs, ok := utxo[string(tx.ID)]
+				if !ok {
+					outs.Outputs = map[int64]TXOutput{}
+					utxo[string(tx.ID)] = outs
+				}
+				outs.Outputs[int64(i)] = out
 			}
 			if !tx.IsCoinbase() {
 				for _, in := range tx.Vin {
@@ -199,10 +626,16 @@ func (bc *Blockchain) FindUnspentTXO(pubKeyHash []byte) []TXOutput {
 	return utxos
 }
 
-func (bc *Blockchain) NewUTXOTransaction(from string, to string, amount int64) (*Transaction, error) {
-	var inputs []TXInput
-	var outputs []TXOutput
-	wallets, err := NewWallets(WalletFile)
+// creates transaction paying amount to the address, inputs exceed outputs by fee which goes to the miner
+func (bc *Blockchain) NewUTXOTransaction(from string, to string, amount int64, fee int64, locks PaymentLocks, hashType byte) (*Transaction, error) {
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("INVALID AMOUNT OR FEE")
+	}
+	err := bc.params.CheckAddress(to)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := NewWallets(bc.params.WalletFile)
 	if err != nil {
 		return nil, err
 	}
@@ -214,40 +647,51 @@ func (bc *Blockchain) NewUTXOTransaction(from string, to string, amount int64) (
 		if err != nil {
 			return nil, err
 		}
-		bal, txOuts, err = bc.utxoset.FindSpendableOuts(pubKey, amount)
+		bal, txOuts, err = bc.utxoset.FindSpendableOuts(pubKey, amount+fee)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		bal, txOuts, err = bc.FindSpendableOuts(from, amount)
+		bal, txOuts, err = bc.FindSpendableOuts(from, amount+fee)
+	}
+	if err != nil {
+		return nil, err
 	}
+	tx, err := newSpendingTX(from, to, amount, fee, bal, txOuts, locks)
 	if err != nil {
 		return nil, err
 	}
-	if bal < amount {
+	err = bc.SignTransaction(tx, wallet, hashType)
+	return tx, err
+}
+
+// creates unsigned transaction spending txOuts worth bal, change is returned to from
+func newSpendingTX(from string, to string, amount int64, fee int64, bal int64, txOuts map[string][]int64, locks PaymentLocks) (*Transaction, error) {
+	if bal < amount+fee {
 		return nil, errors.New("NOT ENOUGH FUNDS")
 	}
+	var inputs []TXInput
+	var outputs []TXOutput
 	for txIDstr, outs := range txOuts {
 		txId := []byte(txIDstr)
 		for _, out := range outs {
-			inputs = append(inputs, TXInput{TxID: txId, Vout: out, Signature: nil, PubKey: wallet.PublicKey})
+			inputs = append(inputs, TXInput{TxID: txId, Vout: out, ScriptSig: nil, Sequence: SequenceFinal})
 		}
 	}
-	outputs = append(outputs, *NewTXO(amount, to))
-	if bal > amount {
-		outputs = append(outputs, *NewTXO(bal-amount, from))
+	payment, err := locks.output(amount, to)
+	if err != nil {
+		return nil, err
 	}
-	tx := &Transaction{
-		Vin:  inputs,
-		Vout: outputs,
+	outputs = append(outputs, *payment)
+	if bal > amount+fee {
+		outputs = append(outputs, *NewTXO(bal-amount-fee, from))
 	}
-	hash, err := tx.Hash()
+	tx := &Transaction{Vin: inputs, Vout: outputs, LockTime: locks.LockTime}
+	tx.ID, err = tx.computeID()
 	if err != nil {
 		return nil, err
 	}
-	tx.ID = hash
-	err = bc.SignTransaction(tx, wallet.PrivateKey)
-	return tx, err
+	return tx, nil
 }
 
 func (bc *Blockchain) FindSpendableOuts(from string, amount int64) (int64, map[string][]int64, error) {
@@ -262,7 +706,7 @@ mainloop:
 	for _, tx := range unspentTXs {
 		strTxID := string(tx.ID)
 		for i, out := range tx.Vout {
-			if out.IsLockedWith(pubKey) {
+			if out.IsLockedWith(pubKey) && !bc.mempool.isSpent(tx.ID, int64(i)) {
 				balance += out.Value
 				spendableOuts[strTxID] = append(spendableOuts[strTxID], int64(i))
 			}
@@ -287,7 +731,7 @@ func (bc *Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
 	return nil, errors.New("Transaction is not found")
 }
 
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+func (bc *Blockchain) SignTransaction(tx *Transaction, wallet Wallet, hashType byte) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
@@ -297,23 +741,70 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 		}
 		prevTXs[string(prevTX.ID)] = *prevTX
 	}
-	tx.Sign(privKey, prevTXs)
-	return nil
+	return tx.Sign(wallet, prevTXs, hashType)
 }
 
+// verifies transaction spending outputs of the chain, coinbase outputs must be mature
+// and time locks must be reached in the next block
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) (bool, error) {
+	tip, err := bc.tipHeader()
+	if err != nil {
+		return false, err
+	}
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			err = bc.utxoset.checkMaturity(vin.TxID, tip.Height+1)
+			if err != nil {
+				return false, err
+			}
+		}
+		err = bc.checkLocks(tx, nil, tip.Height+1, tip)
+		if err != nil {
+			return false, err
+		}
+	}
+	return bc.verifyTransactionWith(tx, nil)
+}
+
+// verifies transaction which inputs can refer to the chain or to pending transactions.
+// scripts of all inputs must succeed, outputs can't exceed inputs and values must be in money range
+func (bc *Blockchain) verifyTransactionWith(tx *Transaction, pending map[string]*Transaction) (bool, error) {
 	if tx.IsCoinbase() {
 		return true, nil
 	}
+	if len(tx.Vin) == 0 {
+		return false, nil
+	}
 	prevTXs := make(map[string]Transaction)
+	var inSum, outSum int64
+	var ok bool
 	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.TxID)
-		if err != nil {
-			return false, err
+		prevTX, ok := pending[string(vin.TxID)]
+		if !ok {
+			var err error
+			prevTX, err = bc.FindTransaction(vin.TxID)
+			if err != nil {
+				return false, err
+			}
+		}
+		if vin.Vout < 0 || vin.Vout >= int64(len(prevTX.Vout)) {
+			return false, nil
+		}
+		inSum, ok = addMoney(inSum, prevTX.Vout[vin.Vout].Value)
+		if !ok {
+			return false, nil
 		}
 		prevTXs[string(prevTX.ID)] = *prevTX
 	}
-
+	for _, out := range tx.Vout {
+		outSum, ok = addMoney(outSum, out.Value)
+		if !ok {
+			return false, nil
+		}
+	}
+	if outSum > inSum {
+		return false, nil
+	}
 	return tx.Verify(prevTXs)
 }
 
@@ -322,11 +813,7 @@ func (bc *Blockchain) GetBestHeight() (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	lastBlockSer, err := bc.db.GetBlock(lastHash)
-	if err != nil {
-		return 0, err
-	}
-	lastBlock, err := DeserializeBlock(lastBlockSer)
+	lastBlock, err := bc.GetBlock(lastHash)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+)
+
+// rules and defaults which differ between networks
+type ChainParams struct {
+	Name string
+	// marks the start of every network message, nodes of different networks can't talk
+	Magic       uint32
+	DefaultPort string
+	Seeds       []string
+	// version written before public key hash in addresses
+	AddressVersion uint32
+	// version of pay to script hash addresses
+	ScriptAddressVersion uint32
+	DBFile               string
+	WalletFile           string
+	// headers and wallet transactions of light client
+	LightDBFile string
+
+	// genesis block is built from it and checked against its hash, it can be replaced with a spec file
+	Genesis *GenesisSpec
+	// the easiest allowed target
+	PowLimit *big.Int
+	// number of blocks between difficulty adjustments
+	RetargetInterval uint64
+	// desired time between blocks in seconds
+	TargetSpacing int64
+	// target is never adjusted if set
+	NoRetargeting bool
+
+	// coins created by the genesis block, halved every HalvingInterval blocks
+	InitialSubsidy  int64
+	HalvingInterval uint64
+	// number of blocks which must be mined on top of a coinbase before its outputs can be spent
+	CoinbaseMaturity uint64
+}
+
+// target changes at most this many times per adjustment
+const maxRetargetFactor = 4
+
+var MainnetParams = &ChainParams{
+	Name:                 "mainnet",
+	Magic:                0x4243484e,
+	DefaultPort:          "13334",
+	Seeds:                []string{"localhost:13335"},
+	AddressVersion:       1,
+	ScriptAddressVersion: 5,
+	DBFile:               "./blocks.db",
+	WalletFile:           "./wallets.dat",
+	LightDBFile:          "./headers.db",
+	Genesis: &GenesisSpec{
+		Timestamp:   1700000000,
+		Nbits:       0x1f010000,
+		Message:     "bchain mainnet genesis",
+		Allocations: []GenesisAllocation{},
+		Nonce:       23462,
+		Hash:        "00001319fc276b1fe8b2b8b44f647c1aab571a7c62b8868a66d338102665a3d4",
+	},
+	PowLimit:         new(big.Int).Lsh(big.NewInt(1), 244),
+	RetargetInterval: 10,
+	TargetSpacing:    30,
+	InitialSubsidy:   50,
+	HalvingInterval:  1000,
+	CoinbaseMaturity: 10,
+}
+
+var TestnetParams = &ChainParams{
+	Name:                 "testnet",
+	Magic:                0x42434854,
+	DefaultPort:          "13434",
+	Seeds:                []string{"localhost:13435"},
+	AddressVersion:       111,
+	ScriptAddressVersion: 196,
+	DBFile:               "./blocks-testnet.db",
+	WalletFile:           "./wallets-testnet.dat",
+	LightDBFile:          "./headers-testnet.db",
+	Genesis: &GenesisSpec{
+		Timestamp:   1700000000,
+		Nbits:       0x1f100000,
+		Message:     "bchain testnet genesis",
+		Allocations: []GenesisAllocation{},
+		Nonce:       4490,
+		Hash:        "0000c14e844fbb23c7a6512816421a4670db4092829e8823c55ab24e532cdba5",
+	},
+	PowLimit:         new(big.Int).Lsh(big.NewInt(1), 248),
+	RetargetInterval: 10,
+	TargetSpacing:    10,
+	InitialSubsidy:   50,
+	HalvingInterval:  1000,
+	CoinbaseMaturity: 10,
+}
+
+// local network with trivial difficulty for tests, it has no seeds
+var RegtestParams = &ChainParams{
+	Name:                 "regtest",
+	Magic:                0x42434852,
+	DefaultPort:          "13534",
+	Seeds:                []string{},
+	AddressVersion:       112,
+	ScriptAddressVersion: 197,
+	DBFile:               "./blocks-regtest.db",
+	WalletFile:           "./wallets-regtest.dat",
+	LightDBFile:          "./headers-regtest.db",
+	Genesis: &GenesisSpec{
+		Timestamp:   1700000000,
+		Nbits:       0x207fffff,
+		Message:     "bchain regtest genesis",
+		Allocations: []GenesisAllocation{},
+		Nonce:       1,
+		Hash:        "5599cc3b9c45119255cdfbe880c63b5043fcda86952bacf02479c4909952d767",
+	},
+	PowLimit:         new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(1)),
+	RetargetInterval: 10,
+	TargetSpacing:    1,
+	NoRetargeting:    true,
+	InitialSubsidy:   50,
+	HalvingInterval:  150,
+	CoinbaseMaturity: 1,
+}
+
+func GetChainParams(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{MainnetParams, TestnetParams, RegtestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, errors.New("UNKNOWN NETWORK")
+}
+
+// checks that address belongs to the network
+func (p *ChainParams) CheckAddress(address string) error {
+	if b, err := ValidateAddress(address); err != nil || !b {
+		return errors.New("INVALID ADDRESS")
+	}
+	version, err := ExtractVersion(address)
+	if err != nil {
+		return err
+	}
+	if version != p.AddressVersion && version != p.ScriptAddressVersion {
+		return errors.New("ADDRESS OF ANOTHER NETWORK")
+	}
+	return nil
+}
+
+// checks if the address has script version of one of the networks
+func IsScriptAddress(address string) bool {
+	version, err := ExtractVersion(address)
+	if err != nil {
+		return false
+	}
+	for _, params := range []*ChainParams{MainnetParams, TestnetParams, RegtestParams} {
+		if params.ScriptAddressVersion == version {
+			return true
+		}
+	}
+	return false
+}
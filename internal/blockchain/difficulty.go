@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+// returns target of the block following parent. headers which are not stored yet are looked up in pending
+func (bc *Blockchain) expectedBits(parent *BlockHeader, pending map[string]*BlockHeader) (uint32, error) {
+	return nextBits(bc.params, parent, func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, pending)
+	})
+}
+
+// target is adjusted on every RetargetInterval height by time spent on the previous
+// blocks, otherwise it is the target of parent. ancestors of parent are found with getHeader
+func nextBits(params *ChainParams, parent *BlockHeader, getHeader func([]byte) (*BlockHeader, error)) (uint32, error) {
+	if params.NoRetargeting || (parent.Height+1)%params.RetargetInterval != 0 {
+		return parent.Nbits, nil
+	}
+	first := parent
+	for first.Height > 0 && parent.Height-first.Height < params.RetargetInterval {
+		prev, err := getHeader(first.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		first = prev
+	}
+	return retarget(params, parent, first), nil
+}
+
+// scales target of last by ratio of actual and desired time between first and last
+func retarget(params *ChainParams, last *BlockHeader, first *BlockHeader) uint32 {
+	expected := int64(last.Height-first.Height) * params.TargetSpacing
+	actual := last.Timestamp - first.Timestamp
+	if actual < expected/maxRetargetFactor {
+		actual = expected / maxRetargetFactor
+	}
+	if actual > expected*maxRetargetFactor {
+		actual = expected * maxRetargetFactor
+	}
+	target := getTarget(last.Nbits)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+	return getCompact(target)
+}
+
+// compact target must be positive and not easier than the network limit, so work of a header
+// with unknown ancestors is bounded before anything is known about its chain
+func checkTarget(params *ChainParams, nbits uint32) error {
+	target := getTarget(nbits)
+	if target.Sign() <= 0 || target.Cmp(params.PowLimit) > 0 {
+		return ErrBadTarget
+	}
+	return nil
+}
+
+// block with unknown parent is kept only if its target is not easier than the tip target
+// can become after one retarget, so orphans can't be made without real work
+func (bc *Blockchain) checkOrphanTarget(nbits uint32) error {
+	tip, err := bc.getHeader(bc.tip, nil)
+	if err != nil {
+		return err
+	}
+	limit := getTarget(tip.Nbits)
+	limit.Mul(limit, big.NewInt(maxRetargetFactor))
+	if getTarget(nbits).Cmp(limit) > 0 {
+		return ErrBadTarget
+	}
+	return nil
+}
+
+func (bc *Blockchain) getHeader(hash []byte, pending map[string]*BlockHeader) (*BlockHeader, error) {
+	if header, ok := pending[string(hash)]; ok {
+		return header, nil
+	}
+	block, err := bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	header := block.Header()
+	return &header, nil
+}
@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+type GenesisAllocation struct {
+	Address string `json:"address"`
+	Value   int64  `json:"value"`
+}
+
+// everything the genesis block is built from, so every node gets the same block
+type GenesisSpec struct {
+	Timestamp   int64               `json:"timestamp"`
+	Nbits       uint32              `json:"nbits"`
+	Message     string              `json:"message"`
+	Allocations []GenesisAllocation `json:"allocations"`
+	// proof of work of the block found by MineGenesis, it is checked instead of mining on every start
+	Nonce uint64 `json:"nonce"`
+	Hash  string `json:"hash"`
+}
+
+var ErrGenesisNotMined = errors.New("GENESIS NONCE AND HASH ARE NOT SET")
+
+func LoadGenesisSpec(path string) (*GenesisSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := new(GenesisSpec)
+	err = json.Unmarshal(data, spec)
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// sum of allocations, coins created by the genesis block
+func (spec *GenesisSpec) Value() int64 {
+	var total int64
+	for _, alloc := range spec.Allocations {
+		total += alloc.Value
+	}
+	return total
+}
+
+// builds genesis block from the spec of the network, its nonce and hash are taken from the spec
+// and the hash must be the one of the block
+func (p *ChainParams) GenesisBlock() (*Block, error) {
+	block, err := p.genesisTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if p.Genesis.Hash == "" {
+		return nil, ErrGenesisNotMined
+	}
+	hash, err := hex.DecodeString(p.Genesis.Hash)
+	if err != nil {
+		return nil, err
+	}
+	block.Nonce = p.Genesis.Nonce
+	block.Hash = hash
+	if !block.Validate() {
+		return nil, errors.New("GENESIS HASH DOES NOT MATCH THE SPEC")
+	}
+	return block, nil
+}
+
+// searches nonce of the genesis block of the spec, it is needed once for a new spec.
+// the search starts from zero, so the same nonce is found every time
+func (p *ChainParams) MineGenesis() (*Block, error) {
+	block, err := p.genesisTemplate()
+	if err != nil {
+		return nil, err
+	}
+	pow := NewPoW(&block.BlockHeader)
+	pow.RunParallel()
+	return block, nil
+}
+
+// genesis block without proof of work
+func (p *ChainParams) genesisTemplate() (*Block, error) {
+	spec := p.Genesis
+	target := getTarget(spec.Nbits)
+	if target.Sign() <= 0 || target.Cmp(p.PowLimit) > 0 {
+		return nil, errors.New("GENESIS TARGET IS NOT BELOW POW LIMIT")
+	}
+	outputs := []TXOutput{}
+	var total int64
+	for _, alloc := range spec.Allocations {
+		err := p.CheckAddress(alloc.Address)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		total, ok = addMoney(total, alloc.Value)
+		if alloc.Value <= 0 || !ok {
+			return nil, errors.New("INVALID GENESIS ALLOCATION")
+		}
+		outputs = append(outputs, *NewTXO(alloc.Value, alloc.Address))
+	}
+	coinbase, err := NewTX([]TXInput{coinbaseInput(0, spec.Message)}, outputs)
+	if err != nil {
+		return nil, err
+	}
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:   BlockchainVersion,
+			Timestamp: spec.Timestamp,
+			Hash:      []byte{},
+			PrevHash:  []byte{},
+			Nbits:     spec.Nbits,
+			Height:    0,
+		},
+		Transactions: []*Transaction{coinbase},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	return block, nil
+}
@@ -0,0 +1,194 @@
+package blockchain
+
+import (
+	database "bchain/internal/db"
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+// chain of block headers kept by a light client. transactions of its wallet are
+// proven to be in blocks of the chain by merkle proofs, blocks are not stored
+type HeaderChain struct {
+	db     *database.DB
+	params *ChainParams
+	tip    *BlockHeader
+}
+
+// opens header chain stored in db, genesis header of the network is stored if db is empty
+func NewHeaderChain(db *database.DB, params *ChainParams) (*HeaderChain, error) {
+	genesis, err := params.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+	hc := &HeaderChain{db: db, params: params}
+	last, err := db.GetLastHeader()
+	if err != nil {
+		return nil, err
+	}
+	if len(last) > 0 {
+		if !hc.HasHeader(genesis.Hash) {
+			return nil, errors.New("STORED GENESIS DOES NOT MATCH CONFIGURED ONE")
+		}
+		hc.tip, err = hc.GetHeader(last)
+		if err != nil {
+			return nil, err
+		}
+		return hc, nil
+	}
+	err = hc.storeHeader(&genesis.BlockHeader, blockWork(genesis.Nbits))
+	if err != nil {
+		return nil, err
+	}
+	err = db.UpdateLastHeader(genesis.Hash)
+	if err != nil {
+		return nil, err
+	}
+	hc.tip = &genesis.BlockHeader
+	return hc, nil
+}
+
+func (hc *HeaderChain) Params() *ChainParams {
+	return hc.params
+}
+
+func (hc *HeaderChain) Tip() *BlockHeader {
+	return hc.tip
+}
+
+func (hc *HeaderChain) HasHeader(hash []byte) bool {
+	serialized, err := hc.db.GetHeader(hash)
+	return err == nil && len(serialized) > 0
+}
+
+func (hc *HeaderChain) GetHeader(hash []byte) (*BlockHeader, error) {
+	serialized, err := hc.db.GetHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized) == 0 {
+		return nil, errors.New("HEADER IS NOT FOUND")
+	}
+	return DeserializeHeader(serialized)
+}
+
+func (hc *HeaderChain) storeHeader(header *BlockHeader, work *big.Int) error {
+	serialized, err := header.Serialize()
+	if err != nil {
+		return err
+	}
+	err = hc.db.AddHeader(header.Hash, serialized)
+	if err != nil {
+		return err
+	}
+	return hc.db.AddChainWork(header.Hash, work.Bytes())
+}
+
+func (hc *HeaderChain) getChainWork(hash []byte) (*big.Int, error) {
+	work, err := hc.db.GetChainWork(hash)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(work), nil
+}
+
+// validates headers and stores them, tip moves to the branch with the most work.
+// parent of every header must be stored or be one of the previous headers
+func (hc *HeaderChain) AddHeaders(headers []BlockHeader) error {
+	tipWork, err := hc.getChainWork(hc.tip.Hash)
+	if err != nil {
+		return err
+	}
+	for i := range headers {
+		header := &headers[i]
+		if hc.HasHeader(header.Hash) {
+			continue
+		}
+		err = checkTarget(hc.params, header.Nbits)
+		if err != nil {
+			return err
+		}
+		if !header.Validate() {
+			return errors.New("INVALID HEADER HASH")
+		}
+		if len(header.PrevHash) == 0 {
+			return ErrBadGenesis
+		}
+		parent, err := hc.GetHeader(header.PrevHash)
+		if err != nil {
+			return errors.New("UNKNOWN HEADER PARENT")
+		}
+		if header.Height != parent.Height+1 {
+			return errors.New("INVALID HEADER HEIGHT")
+		}
+		nbits, err := nextBits(hc.params, parent, hc.GetHeader)
+		if err != nil {
+			return err
+		}
+		if header.Nbits != nbits {
+			return errors.New("INVALID HEADER TARGET")
+		}
+		err = checkTimestamp(header, parent, hc.GetHeader)
+		if err != nil {
+			return err
+		}
+		work, err := hc.getChainWork(parent.Hash)
+		if err != nil {
+			return err
+		}
+		work.Add(work, blockWork(header.Nbits))
+		err = hc.storeHeader(header, work)
+		if err != nil {
+			return err
+		}
+		if work.Cmp(tipWork) > 0 {
+			err = hc.db.UpdateLastHeader(header.Hash)
+			if err != nil {
+				return err
+			}
+			hc.tip = header
+			tipWork = work
+		}
+	}
+	return nil
+}
+
+// returns hashes from the tip back to genesis with exponentially growing gaps
+func (hc *HeaderChain) GetBlockLocator() ([][]byte, error) {
+	locator := [][]byte{}
+	step, next := 1, 0
+	header := hc.tip
+	for i := 0; ; i++ {
+		if i == next || len(header.PrevHash) == 0 {
+			locator = append(locator, header.Hash)
+			if len(locator) >= locatorDenseLen {
+				step *= 2
+			}
+			next += step
+		}
+		if len(header.PrevHash) == 0 {
+			return locator, nil
+		}
+		prev, err := hc.GetHeader(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		header = prev
+	}
+}
+
+// checks that the block is an ancestor of the tip
+func (hc *HeaderChain) IsInBestChain(hash []byte) (bool, error) {
+	target, err := hc.GetHeader(hash)
+	if err != nil {
+		return false, err
+	}
+	header := hc.tip
+	for header.Height > target.Height {
+		header, err = hc.GetHeader(header.PrevHash)
+		if err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(header.Hash, target.Hash), nil
+}
@@ -0,0 +1,237 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// size of the secret of hash time locked contract. it is fixed, so the contract can't be
+// redeemed on one chain with a secret which is too big to be used on the other one
+const SecretSize = 32
+
+// hash time locked contract paid to by pay to script hash output. recipient can spend it with
+// the secret which hash is in the contract, after the lock time sender can take it back
+type HTLC struct {
+	SecretHash    []byte
+	RecipientHash []byte
+	RefundHash    []byte
+	// height or unix time the contract can be refunded from
+	LockTime int64
+}
+
+var ErrNotHTLC = errors.New("SCRIPT IS NOT HASH TIME LOCKED CONTRACT")
+
+// unix time the swap contract can be refunded from, contracts of a swap are on different chains,
+// so their locks are times rather than heights which can't be compared between the chains
+func SwapLockTime(now int64, duration uint64) (uint64, error) {
+	lock := uint64(now) + duration
+	if duration == 0 || lock < LockTimeThreshold {
+		return 0, errors.New("INVALID CONTRACT DURATION")
+	}
+	return lock, nil
+}
+
+// checks that the participant contract with the lock time can be refunded well before the
+// initiator contract: at least a third of the time left until the initiator refund must remain
+// after it. otherwise the initiator could redeem the participant contract right before its refund
+// and take back its own contract before the participant uses the secret
+func CheckParticipantLock(initiator *HTLC, lockTime uint64, now int64) error {
+	if initiator.LockTime < LockTimeThreshold {
+		return errors.New("INITIATOR CONTRACT IS NOT LOCKED BY TIME")
+	}
+	left := initiator.LockTime - now
+	if left <= 0 || (int64(lockTime)-now)*3 > left*2 {
+		return errors.New("CONTRACT MUST BE REFUNDABLE WELL BEFORE INITIATOR CONTRACT")
+	}
+	return nil
+}
+
+// creates contract paying to the recipient with the secret or back to the refund address after the lock time
+func NewHTLC(secretHash []byte, recipient string, refund string, lockTime uint64) (*HTLC, error) {
+	if len(secretHash) != sha256.Size {
+		return nil, errors.New("INVALID SECRET HASH")
+	}
+	if lockTime == 0 || lockTime >= 1<<39 {
+		return nil, errors.New("INVALID CONTRACT LOCK TIME")
+	}
+	if IsScriptAddress(recipient) || IsScriptAddress(refund) {
+		return nil, errors.New("CONTRACT CAN'T PAY TO SCRIPT ADDRESS")
+	}
+	recipientHash, err := ExtractPubKeyHash(recipient)
+	if err != nil {
+		return nil, err
+	}
+	refundHash, err := ExtractPubKeyHash(refund)
+	if err != nil {
+		return nil, err
+	}
+	return &HTLC{secretHash, recipientHash, refundHash, int64(lockTime)}, nil
+}
+
+// OP_IF OP_SIZE <32> OP_EQUALVERIFY OP_SHA256 <secret hash> OP_EQUALVERIFY OP_DUP OP_SHA256 <recipient hash>
+// OP_ELSE <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_SHA256 <refund hash>
+// OP_ENDIF OP_EQUALVERIFY OP_CHECKSIG
+func (c *HTLC) Script() []byte {
+	return NewScriptBuilder().
+		AddOp(OpIf).
+		AddOp(OpSize).AddInt(SecretSize).AddOp(OpEqualVerify).
+		AddOp(OpSHA256).AddData(c.SecretHash).AddOp(OpEqualVerify).
+		AddOp(OpDup).AddOp(OpSHA256).AddData(c.RecipientHash).
+		AddOp(OpElse).
+		AddInt(c.LockTime).AddOp(OpCheckLockTimeVerify).AddOp(OpDrop).
+		AddOp(OpDup).AddOp(OpSHA256).AddData(c.RefundHash).
+		AddOp(OpEndIf).
+		AddOp(OpEqualVerify).AddOp(OpCheckSig).Script()
+}
+
+// returns pay to script hash address of the contract
+func (c *HTLC) Address(version uint32) (string, error) {
+	scriptHash := sha256.Sum256(c.Script())
+	return GetAddress(scriptHash[:], version)
+}
+
+// parses contract script, it must be exactly in the form built by Script
+func ExtractHTLC(script []byte) (*HTLC, error) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 20 || !ops[11].isPush() {
+		return nil, ErrNotHTLC
+	}
+	lock := int64(smallInt(ops[11]))
+	if lock < 0 {
+		lock, err = scriptNum(ops[11].data, 5)
+		if err != nil || lock <= 0 {
+			return nil, ErrNotHTLC
+		}
+	}
+	c := &HTLC{SecretHash: ops[5].data, RecipientHash: ops[9].data, RefundHash: ops[16].data, LockTime: lock}
+	if len(c.SecretHash) != sha256.Size || len(c.RecipientHash) != 32 || len(c.RefundHash) != 32 ||
+		!bytes.Equal(c.Script(), script) {
+		return nil, ErrNotHTLC
+	}
+	return c, nil
+}
+
+// returns transaction with the id and index of its output paying to the contract
+func (bc *Blockchain) FindContractOutput(contract []byte, txID []byte) (*Transaction, int64, error) {
+	tx, err := bc.FindTransaction(txID)
+	if err != nil {
+		return nil, 0, err
+	}
+	scriptHash := sha256.Sum256(contract)
+	for i, out := range tx.Vout {
+		hash, ok := out.ScriptHash()
+		if ok && bytes.Equal(hash, scriptHash[:]) {
+			return tx, int64(i), nil
+		}
+	}
+	return nil, 0, errors.New("TRANSACTION DOESN'T PAY TO THE CONTRACT")
+}
+
+// creates transaction spending the contract output to the recipient with the secret
+func (bc *Blockchain) NewRedeemTransaction(contract []byte, txID []byte, secret []byte, fee int64, hashType byte) (*Transaction, error) {
+	c, err := ExtractHTLC(contract)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(secret)
+	if len(secret) != SecretSize || !bytes.Equal(hash[:], c.SecretHash) {
+		return nil, errors.New("SECRET DOESN'T MATCH THE CONTRACT")
+	}
+	return bc.spendContract(contract, txID, fee, c.RecipientHash, 0, hashType, func(b *ScriptBuilder) {
+		b.AddData(secret).AddInt(1)
+	})
+}
+
+// creates transaction returning the contract output to the sender, it is valid from the contract lock time
+func (bc *Blockchain) NewRefundTransaction(contract []byte, txID []byte, fee int64, hashType byte) (*Transaction, error) {
+	c, err := ExtractHTLC(contract)
+	if err != nil {
+		return nil, err
+	}
+	return bc.spendContract(contract, txID, fee, c.RefundHash, uint64(c.LockTime), hashType, func(b *ScriptBuilder) {
+		b.AddOp(Op0)
+	})
+}
+
+// spends the contract output to the wallet with the key hash. branch adds pushes selecting
+// the branch of the contract after the signature and the public key
+func (bc *Blockchain) spendContract(contract []byte, txID []byte, fee int64, pubKeyHash []byte, lockTime uint64, hashType byte, branch func(*ScriptBuilder)) (*Transaction, error) {
+	prevTX, vout, err := bc.FindContractOutput(contract, txID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := bc.utxoset.FindOutput(prevTX.ID, vout)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		return nil, errors.New("CONTRACT OUTPUT IS ALREADY SPENT")
+	}
+	if fee < 0 || out.Value <= fee {
+		return nil, errors.New("INVALID FEE")
+	}
+	address, err := GetAddress(pubKeyHash, bc.params.AddressVersion)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := NewWallets(bc.params.WalletFile)
+	if err != nil {
+		return nil, err
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		return nil, errors.New("WALLET OF THE CONTRACT IS NOT FOUND")
+	}
+	tx := &Transaction{
+		Vin:      []TXInput{{TxID: prevTX.ID, Vout: vout, Sequence: SequenceFinal}},
+		Vout:     []TXOutput{*NewTXO(out.Value-fee, address)},
+		LockTime: lockTime,
+	}
+	tx.ID, err = tx.computeID()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := tx.signInput(0, contract, wallet.PrivateKey, hashType)
+	if err != nil {
+		return nil, err
+	}
+	b := NewScriptBuilder().AddData(signature).AddData(wallet.PublicKey)
+	branch(b)
+	tx.Vin[0].ScriptSig = b.AddData(contract).Script()
+	return tx, nil
+}
+
+// returns secret revealed by the transaction which redeemed the contract output,
+// nil if the output is not spent or was refunded
+func (bc *Blockchain) FindContractSecret(contract []byte, txID []byte) ([]byte, error) {
+	c, err := ExtractHTLC(contract)
+	if err != nil {
+		return nil, err
+	}
+	_, vout, err := bc.FindContractOutput(contract, txID)
+	if err != nil {
+		return nil, err
+	}
+	bci := bc.Iterator()
+	for bci.Next() {
+		for _, tx := range bci.Block().Transactions {
+			for _, vin := range tx.Vin {
+				if !bytes.Equal(vin.TxID, txID) || vin.Vout != vout {
+					continue
+				}
+				pushes := scriptPushes(vin.ScriptSig)
+				if len(pushes) != 5 {
+					return nil, nil
+				}
+				secret := pushes[2]
+				hash := sha256.Sum256(secret)
+				if !bytes.Equal(hash[:], c.SecretHash) {
+					return nil, nil
+				}
+				return secret, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,203 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// validated transactions which are not included in a block yet
+type Mempool struct {
+	bc  *Blockchain
+	mu  sync.Mutex
+	txs map[string]*Transaction
+	// ids in order of addition, so parents go before their children
+	order [][]byte
+	// outputs spent by pool transactions mapped to id of the spending transaction
+	spent map[string][]byte
+}
+
+func NewMempool(bc *Blockchain) *Mempool {
+	return &Mempool{
+		bc:    bc,
+		txs:   map[string]*Transaction{},
+		order: [][]byte{},
+		spent: map[string][]byte{},
+	}
+}
+
+func outpointKey(txID []byte, vout int64) string {
+	return string(txID) + ":" + strconv.FormatInt(vout, 10)
+}
+
+// validates transaction and adds it to the pool.
+// inputs must spend outputs from utxo set or from other pool transactions which are not spent yet,
+// coinbase outputs must be mature and time locks must be reached in the next block
+func (mp *Mempool) Add(tx *Transaction) error {
+	// utxo set and tip must not change during validation, chain lock is taken before pool lock
+	mp.bc.mu.Lock()
+	defer mp.bc.mu.Unlock()
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.add(tx)
+}
+
+// must be called with chain and pool locks held
+func (mp *Mempool) add(tx *Transaction) error {
+	if _, ok := mp.txs[string(tx.ID)]; ok {
+		return errors.New("TRANSACTION IS ALREADY IN MEMPOOL")
+	}
+	if len(tx.Vin) == 0 || tx.IsCoinbase() {
+		return errors.New("INVALID MEMPOOL TRANSACTION")
+	}
+	if id, err := tx.computeID(); err != nil || !bytes.Equal(id, tx.ID) {
+		return errors.New("TRANSACTION ID DOES NOT MATCH ITS DATA")
+	}
+	tip, err := mp.bc.tipHeader()
+	if err != nil {
+		return err
+	}
+	height := tip.Height
+	inputs := map[string]bool{}
+	for _, vin := range tx.Vin {
+		key := outpointKey(vin.TxID, vin.Vout)
+		if _, ok := mp.spent[key]; ok || inputs[key] {
+			return errors.New("DOUBLE SPEND IN MEMPOOL")
+		}
+		inputs[key] = true
+		if _, ok := mp.txs[string(vin.TxID)]; ok {
+			continue
+		}
+		out, err := mp.bc.utxoset.FindOutput(vin.TxID, vin.Vout)
+		if err != nil {
+			return err
+		}
+		if out == nil {
+			return errors.New("INPUT IS SPENT OR DOES NOT EXIST")
+		}
+		err = mp.bc.utxoset.checkMaturity(vin.TxID, height+1)
+		if err != nil {
+			return err
+		}
+	}
+	err = mp.bc.checkLocks(tx, mp.txs, height+1, tip)
+	if err != nil {
+		return err
+	}
+	if b, err := mp.bc.verifyTransactionWith(tx, mp.txs); err != nil || !b {
+		return errors.New("INVALID TRANSACTION")
+	}
+	mp.txs[string(tx.ID)] = tx
+	mp.order = append(mp.order, tx.ID)
+	for _, vin := range tx.Vin {
+		mp.spent[outpointKey(vin.TxID, vin.Vout)] = tx.ID
+	}
+	return nil
+}
+
+func (mp *Mempool) Get(id []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	tx, ok := mp.txs[string(id)]
+	return tx, ok
+}
+
+// checks if the output is spent by a pool transaction
+func (mp *Mempool) isSpent(txID []byte, vout int64) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	_, ok := mp.spent[outpointKey(txID, vout)]
+	return ok
+}
+
+func (mp *Mempool) Has(id []byte) bool {
+	_, ok := mp.Get(id)
+	return ok
+}
+
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.txs)
+}
+
+// returns pool transactions in order they can be placed into a block
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	txs := make([]*Transaction, 0, len(mp.order))
+	for _, id := range mp.order {
+		txs = append(txs, mp.txs[string(id)])
+	}
+	return txs
+}
+
+// evicts transactions included in the block and the ones conflicting with them
+func (mp *Mempool) RemoveBlockTransactions(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		if _, ok := mp.txs[string(tx.ID)]; ok {
+			mp.remove(tx.ID, false)
+			continue
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if id, ok := mp.spent[outpointKey(vin.TxID, vin.Vout)]; ok {
+				mp.remove(id, true)
+			}
+		}
+	}
+}
+
+// removes transaction which is not valid anymore together with its descendants
+func (mp *Mempool) evict(id []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.remove(id, true)
+}
+
+// removes transaction, its descendants are removed too if withChildren is set
+func (mp *Mempool) remove(id []byte, withChildren bool) {
+	tx, ok := mp.txs[string(id)]
+	if !ok {
+		return
+	}
+	delete(mp.txs, string(id))
+	for i, oid := range mp.order {
+		if string(oid) == string(id) {
+			mp.order = append(mp.order[:i], mp.order[i+1:]...)
+			break
+		}
+	}
+	for _, vin := range tx.Vin {
+		delete(mp.spent, outpointKey(vin.TxID, vin.Vout))
+	}
+	if !withChildren {
+		return
+	}
+	for i := range tx.Vout {
+		if child, ok := mp.spent[outpointKey(id, int64(i))]; ok {
+			mp.remove(child, true)
+		}
+	}
+}
+
+// clears the pool and adds txs followed by the previous pool transactions, invalid ones are dropped.
+// must be called with chain lock held
+func (mp *Mempool) Rebuild(txs []*Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, id := range mp.order {
+		txs = append(txs, mp.txs[string(id)])
+	}
+	mp.txs = map[string]*Transaction{}
+	mp.order = [][]byte{}
+	mp.spent = map[string][]byte{}
+	for _, tx := range txs {
+		mp.add(tx)
+	}
+}
@@ -1,12 +1,15 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"errors"
 )
 
 type MerkleTree struct {
 	Root *MerkleNode
+	// nodes of every level from leaves to root
+	levels [][]*MerkleNode
 }
 
 type MerkleNode struct {
@@ -15,30 +18,41 @@ type MerkleNode struct {
 	Right *MerkleNode
 }
 
-// non hashed data, panics if data has nil
+// path from a leaf to the root. index is the position of the leaf, its bits
+// tell on which side each sibling is, lowest bit is for the leaf level
+type MerkleProof struct {
+	Index    uint64
+	Siblings [][]byte
+}
+
+// leaves are already hashed data. last node of a level with odd number of nodes
+// is paired with itself, root of a single leaf is the leaf. empty tree has zero root
 func NewMerkleTree(hashedData [][]byte) *MerkleTree {
-	var nodes []*MerkleNode
-	if len(hashedData)%2 != 0 {
-		hashedData = append(hashedData, hashedData[len(hashedData)-1])
+	if len(hashedData) == 0 {
+		return &MerkleTree{Root: NewMerkleLeaf(make([]byte, 32))}
 	}
+	var nodes []*MerkleNode
 	for _, nodeData := range hashedData {
-		// newNode, _ := NewMerkleNode(nil, nil, nodeData)
-		newNode := NewMerkleLeaf(nodeData)
-		nodes = append(nodes, newNode)
+		nodes = append(nodes, NewMerkleLeaf(nodeData))
 	}
-	for i := 0; i < len(hashedData)/2; i++ {
+	levels := [][]*MerkleNode{nodes}
+	for len(nodes) > 1 {
 		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node, err := NewMerkleNode(nodes[j], nodes[j+1], nil)
+			right := nodes[j]
+			if j+1 < len(nodes) {
+				right = nodes[j+1]
+			}
+			node, err := NewMerkleNode(nodes[j], right, nil)
 			if err != nil {
 				panic(err)
 			}
 			newLevel = append(newLevel, node)
 		}
 		nodes = newLevel
+		levels = append(levels, nodes)
 	}
-	tree := MerkleTree{Root: nodes[0]}
-	return &tree
+	return &MerkleTree{Root: nodes[0], levels: levels}
 }
 
 func NewMerkleNode(left, right *MerkleNode, data []byte) (*MerkleNode, error) {
@@ -49,7 +63,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) (*MerkleNode, error) {
 	} else if left == nil || right == nil {
 		return nil, errors.New("INCORRECT DATA FOR NEW MERKLE NODE")
 	} else {
-		hash = sha256.Sum256(append(left.Data, right.Data...))
+		hash = sha256.Sum256(append(append([]byte{}, left.Data...), right.Data...))
 	}
 	newNode.Data = hash[:]
 	return newNode, nil
@@ -62,3 +76,50 @@ func NewMerkleLeaf(hashedData []byte) *MerkleNode {
 func (node *MerkleNode) IsLeaf() bool {
 	return node.Left == nil && node.Right == nil
 }
+
+// returns proof that the leaf is in the tree
+func (t *MerkleTree) Proof(leaf []byte) (*MerkleProof, error) {
+	if len(t.levels) == 0 {
+		return nil, errors.New("LEAF IS NOT IN MERKLE TREE")
+	}
+	index := -1
+	for i, node := range t.levels[0] {
+		if bytes.Equal(node.Data, leaf) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("LEAF IS NOT IN MERKLE TREE")
+	}
+	proof := &MerkleProof{Index: uint64(index), Siblings: [][]byte{}}
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling].Data)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// checks that the leaf and siblings of the proof hash up to the root
+func (p *MerkleProof) Verify(leaf []byte, root []byte) bool {
+	if len(p.Siblings) >= 64 || p.Index>>len(p.Siblings) != 0 {
+		return false
+	}
+	hash := leaf
+	index := p.Index
+	for _, sibling := range p.Siblings {
+		var sum [32]byte
+		if index&1 == 0 {
+			sum = sha256.Sum256(append(append([]byte{}, hash...), sibling...))
+		} else {
+			sum = sha256.Sum256(append(append([]byte{}, sibling...), hash...))
+		}
+		hash = sum[:]
+		index >>= 1
+	}
+	return bytes.Equal(hash, root)
+}
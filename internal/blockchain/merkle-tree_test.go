@@ -0,0 +1,67 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func merkleLeaves(n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("leaf %d", i)))
+		leaves[i] = hash[:]
+	}
+	return leaves
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 7} {
+		leaves := merkleLeaves(n)
+		tree := NewMerkleTree(leaves)
+		root := tree.Root.Data
+		for i, leaf := range leaves {
+			name := fmt.Sprintf("%d leaves, leaf %d", n, i)
+			proof, err := tree.Proof(leaf)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if proof.Index != uint64(i) {
+				t.Fatalf("%s: index %d", name, proof.Index)
+			}
+			if !proof.Verify(leaf, root) {
+				t.Fatalf("%s: valid proof is rejected", name)
+			}
+			if n > 1 && proof.Verify(leaves[(i+1)%n], root) {
+				t.Fatalf("%s: proof is accepted for another leaf", name)
+			}
+
+			cases := map[string]MerkleProof{
+				"wrong index":     {Index: uint64((i + 1) % n), Siblings: proof.Siblings},
+				"extra index bit": {Index: proof.Index | 1<<len(proof.Siblings), Siblings: proof.Siblings},
+			}
+			if n == 1 {
+				cases["wrong index"] = MerkleProof{Index: 1, Siblings: proof.Siblings}
+			} else {
+				siblings := append([][]byte{}, proof.Siblings...)
+				tampered := append([]byte{}, siblings[0]...)
+				tampered[0] ^= 1
+				siblings[0] = tampered
+				cases["tampered sibling"] = MerkleProof{Index: proof.Index, Siblings: siblings}
+			}
+			for c, bad := range cases {
+				if bad.Verify(leaf, root) {
+					t.Errorf("%s: %s is accepted", name, c)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleProofUnknownLeaf(t *testing.T) {
+	tree := NewMerkleTree(merkleLeaves(3))
+	_, err := tree.Proof(make([]byte, 32))
+	if err == nil {
+		t.Fatal("proof is built for leaf which is not in the tree")
+	}
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// transaction spending multisig outputs which is passed between owners of the keys
+// until enough signatures are collected
+type MultisigTX struct {
+	Transaction *Transaction
+	Inputs      []MultisigInput
+}
+
+type MultisigInput struct {
+	RedeemScript []byte `json:"redeem_script"`
+	// signatures keyed by hex of the public key
+	Signatures map[string][]byte `json:"signatures"`
+}
+
+// file form of multisig transaction, transaction is in the canonical encoding
+type multisigFile struct {
+	Transaction []byte          `json:"transaction"`
+	Inputs      []MultisigInput `json:"inputs"`
+}
+
+// creates unsigned transaction spending outputs of multisig address of the wallets
+func (bc *Blockchain) NewMultisigTransaction(from string, to string, amount int64, fee int64) (*MultisigTX, error) {
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("INVALID AMOUNT OR FEE")
+	}
+	err := bc.params.CheckAddress(to)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := NewWallets(bc.params.WalletFile)
+	if err != nil {
+		return nil, err
+	}
+	redeemScript, ok := wallets.Scripts[from]
+	if !ok {
+		return nil, errors.New("MULTISIG ADDRESS IS NOT IN WALLETS")
+	}
+	scriptHash := sha256.Sum256(redeemScript)
+	bal, txOuts, err := bc.utxoset.FindSpendableOuts(scriptHash[:], amount+fee)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := newSpendingTX(from, to, amount, fee, bal, txOuts, PaymentLocks{})
+	if err != nil {
+		return nil, err
+	}
+	mtx := &MultisigTX{Transaction: tx}
+	for range tx.Vin {
+		mtx.Inputs = append(mtx.Inputs, MultisigInput{RedeemScript: redeemScript, Signatures: map[string][]byte{}})
+	}
+	return mtx, nil
+}
+
+// adds signatures of the hash type by the wallet to every input which script has its key,
+// returns number of signed inputs
+func (mtx *MultisigTX) Sign(wallet Wallet, hashType byte) (int, error) {
+	signed := 0
+	for i := range mtx.Transaction.Vin {
+		redeemScript := mtx.Inputs[i].RedeemScript
+		_, pubKeys, ok := extractMultisig(redeemScript)
+		if !ok {
+			return 0, errors.New("INPUT IS NOT MULTISIG")
+		}
+		if !hasKey(pubKeys, wallet.PublicKey) {
+			continue
+		}
+		signature, err := mtx.Transaction.signInput(i, redeemScript, wallet.PrivateKey, hashType)
+		if err != nil {
+			return 0, err
+		}
+		mtx.Inputs[i].Signatures[hex.EncodeToString(wallet.PublicKey)] = signature
+		signed++
+	}
+	if signed == 0 {
+		return 0, errors.New("WALLET KEY IS NOT IN MULTISIG SCRIPT")
+	}
+	return signed, nil
+}
+
+func hasKey(pubKeys [][]byte, pubKey []byte) bool {
+	for _, key := range pubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// builds scriptSigs from collected signatures, they are taken in the order of keys in the script
+func (mtx *MultisigTX) Finalize() (*Transaction, error) {
+	tx := mtx.Transaction
+	for i := range tx.Vin {
+		redeemScript := mtx.Inputs[i].RedeemScript
+		required, pubKeys, ok := extractMultisig(redeemScript)
+		if !ok {
+			return nil, errors.New("INPUT IS NOT MULTISIG")
+		}
+		b := NewScriptBuilder()
+		n := 0
+		for _, pubKey := range pubKeys {
+			signature, ok := mtx.Inputs[i].Signatures[hex.EncodeToString(pubKey)]
+			if !ok || n == required {
+				continue
+			}
+			b.AddData(signature)
+			n++
+		}
+		if n < required {
+			return nil, errors.New("NOT ENOUGH SIGNATURES")
+		}
+		tx.Vin[i].ScriptSig = b.AddData(redeemScript).Script()
+	}
+	return tx, nil
+}
+
+func (mtx *MultisigTX) SaveToFile(path string) error {
+	serialized, err := mtx.Transaction.Serialize()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(multisigFile{Transaction: serialized, Inputs: mtx.Inputs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
+
+func LoadMultisigTX(path string) (*MultisigTX, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := new(multisigFile)
+	err = json.Unmarshal(data, file)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DeserializeTransaction(file.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Inputs) != len(tx.Vin) {
+		return nil, errors.New("INPUTS DO NOT MATCH TRANSACTION")
+	}
+	for i := range file.Inputs {
+		if file.Inputs[i].Signatures == nil {
+			file.Inputs[i].Signatures = map[string][]byte{}
+		}
+	}
+	return &MultisigTX{Transaction: tx, Inputs: file.Inputs}, nil
+}
@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	maxOrphanBlocks = 100
+	// orphans older than this are dropped, their parents are unlikely to arrive
+	orphanMaxAge = 20 * time.Minute
+)
+
+var ErrOrphanBlock = errors.New("ORPHAN BLOCK")
+
+type orphanBlock struct {
+	block *Block
+	added time.Time
+}
+
+// blocks received before their parents
+type OrphanPool struct {
+	mu     sync.Mutex
+	blocks map[string]*orphanBlock
+	// hashes of orphans mapped by their parent hash
+	byPrev map[string][][]byte
+}
+
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		blocks: map[string]*orphanBlock{},
+		byPrev: map[string][][]byte{},
+	}
+}
+
+// adds block to the pool, expired orphans are dropped and
+// the oldest one is evicted if the pool is full
+func (op *OrphanPool) Add(block *Block) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	if _, ok := op.blocks[string(block.Hash)]; ok {
+		return
+	}
+	now := time.Now()
+	var oldest *orphanBlock
+	for _, orphan := range op.blocks {
+		if now.Sub(orphan.added) > orphanMaxAge {
+			op.remove(orphan.block)
+			continue
+		}
+		if oldest == nil || orphan.added.Before(oldest.added) {
+			oldest = orphan
+		}
+	}
+	if len(op.blocks) >= maxOrphanBlocks && oldest != nil {
+		op.remove(oldest.block)
+	}
+	op.blocks[string(block.Hash)] = &orphanBlock{block: block, added: now}
+	op.byPrev[string(block.PrevHash)] = append(op.byPrev[string(block.PrevHash)], block.Hash)
+}
+
+func (op *OrphanPool) Has(hash []byte) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, ok := op.blocks[string(hash)]
+	return ok
+}
+
+func (op *OrphanPool) Size() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return len(op.blocks)
+}
+
+// returns hash of the missing block which the chain of orphans ending with hash waits for
+func (op *OrphanPool) MissingParent(hash []byte) []byte {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	missing := hash
+	for {
+		orphan, ok := op.blocks[string(missing)]
+		if !ok {
+			return missing
+		}
+		missing = orphan.block.PrevHash
+	}
+}
+
+// removes and returns orphans which have the block as their parent
+func (op *OrphanPool) takeChildren(hash []byte) []*Block {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	children := []*Block{}
+	for _, childHash := range op.byPrev[string(hash)] {
+		if orphan, ok := op.blocks[string(childHash)]; ok {
+			children = append(children, orphan.block)
+			delete(op.blocks, string(childHash))
+		}
+	}
+	delete(op.byPrev, string(hash))
+	return children
+}
+
+func (op *OrphanPool) remove(block *Block) {
+	delete(op.blocks, string(block.Hash))
+	siblings := op.byPrev[string(block.PrevHash)]
+	for i, hash := range siblings {
+		if string(hash) == string(block.Hash) {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.byPrev, string(block.PrevHash))
+	} else {
+		op.byPrev[string(block.PrevHash)] = siblings
+	}
+}
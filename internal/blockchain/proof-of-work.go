@@ -8,35 +8,70 @@ import (
 )
 
 type PoW struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
-func getTarget(nBits uint8) *big.Int {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-int(nBits)))
-	return target
+// decodes compact target: the highest byte is the length of the target in bytes,
+// the lower three bytes are its most significant bytes. targets with the sign bit are zero
+func getTarget(nBits uint32) *big.Int {
+	if nBits&0x00800000 != 0 {
+		return big.NewInt(0)
+	}
+	mantissa := big.NewInt(int64(nBits & 0x007fffff))
+	size := uint(nBits >> 24)
+	if size <= 3 {
+		return mantissa.Rsh(mantissa, 8*(3-size))
+	}
+	return mantissa.Lsh(mantissa, 8*(size-3))
+}
+
+// encodes target in compact form, precision is limited by three bytes of mantissa
+func getCompact(target *big.Int) uint32 {
+	size := uint32(len(target.Bytes()))
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+	// the highest mantissa bit is a sign, so such mantissa is moved one byte lower
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+	return size<<24 | mantissa
+}
+
+// expected number of hashes needed to find a block with the target
+func blockWork(nBits uint32) *big.Int {
+	target := getTarget(nBits)
+	target.Add(target, big.NewInt(1))
+	work := big.NewInt(1)
+	work.Lsh(work, 256)
+	return work.Div(work, target)
 }
 
-func NewPoW(b *Block) *PoW {
-	return &PoW{b, getTarget(b.Nbits)}
+// merkle root must be set before mining, only nonce of the header is changed
+func NewPoW(h *BlockHeader) *PoW {
+	return &PoW{h, getTarget(h.Nbits)}
 }
 
 func (pow *PoW) GetData() []byte {
-	return pow.block.GetData()
+	return pow.header.GetData()
 }
 
 func (pow *PoW) Run() (hash [32]byte) {
-	for pow.block.Nonce < math.MaxUint64 {
+	for pow.header.Nonce < math.MaxUint64 {
 		data := pow.GetData()
 		hash = sha256.Sum256(data)
 		var hashNum big.Int
 		hashNum.SetBytes(hash[:])
 		if hashNum.Cmp(pow.target) == -1 {
-			pow.block.Hash = hash[:]
+			pow.header.Hash = hash[:]
 			return
 		}
-		pow.block.Nonce++
+		pow.header.Nonce++
 	}
 	return
 }
@@ -44,16 +79,16 @@ func (pow *PoW) Run() (hash [32]byte) {
 func (pow *PoW) RunParallel() [32]byte {
 	const computeSize = 32
 	computeArr := [computeSize][32]byte{}
-	for pow.block.Nonce < math.MaxUint64 {
+	for pow.header.Nonce < math.MaxUint64 {
 		wg := sync.WaitGroup{}
 		for i := range computeArr {
-			if !(uint64(i)+pow.block.Nonce < math.MaxUint64) {
+			if !(uint64(i)+pow.header.Nonce < math.MaxUint64) {
 				break
 			}
 			wg.Add(1)
 			go func(delta uint64) {
 				defer wg.Done()
-				data := pow.block.getDataNonce(pow.block.Nonce + uint64(delta))
+				data := pow.header.getDataNonce(pow.header.Nonce + uint64(delta))
 				computeArr[delta] = sha256.Sum256(data)
 
 			}(uint64(i))
@@ -62,12 +97,12 @@ func (pow *PoW) RunParallel() [32]byte {
 		for i := range computeArr {
 			var hashNum big.Int
 			if hashNum.SetBytes(computeArr[i][:]); hashNum.Cmp(pow.target) == -1 {
-				pow.block.Hash = computeArr[i][:]
-				pow.block.Nonce += uint64(i)
+				pow.header.Hash = computeArr[i][:]
+				pow.header.Nonce += uint64(i)
 				return computeArr[i]
 			}
 		}
-		pow.block.Nonce += computeSize
+		pow.header.Nonce += computeSize
 	}
 	return [32]byte{}
 }
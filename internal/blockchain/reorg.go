@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrInvalidBlock  = errors.New("BLOCK IS KNOWN TO BE INVALID")
+	ErrInvalidParent = errors.New("BLOCK EXTENDS INVALID BLOCK")
+)
+
+// returns cumulative work of the chain ending with the block,
+// work of blocks stored without it is computed from their parents
+func (bc *Blockchain) getChainWork(hash []byte) (*big.Int, error) {
+	work := big.NewInt(0)
+	pending := []*Block{}
+	for len(hash) > 0 {
+		stored, err := bc.db.GetChainWork(hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(stored) > 0 {
+			work.SetBytes(stored)
+			break
+		}
+		block, err := bc.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, block)
+		hash = block.PrevHash
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		work.Add(work, blockWork(pending[i].Nbits))
+		err := bc.db.AddChainWork(pending[i].Hash, work.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return work, nil
+}
+
+// returns the last common block of two branches
+func (bc *Blockchain) findForkPoint(a *Block, b *Block) (*Block, error) {
+	var err error
+	for a.Height > b.Height {
+		if a, err = bc.GetBlock(a.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+	for b.Height > a.Height {
+		if b, err = bc.GetBlock(b.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+	for !bytes.Equal(a.Hash, b.Hash) {
+		if len(a.PrevHash) == 0 || len(b.PrevHash) == 0 {
+			return nil, errors.New("BRANCHES HAVE DIFFERENT GENESIS")
+		}
+		if a, err = bc.GetBlock(a.PrevHash); err != nil {
+			return nil, err
+		}
+		if b, err = bc.GetBlock(b.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// returns blocks after the ancestor up to the block in order from the oldest
+func (bc *Blockchain) getBranch(ancestor *Block, block *Block) ([]*Block, error) {
+	branch := []*Block{}
+	for !bytes.Equal(block.Hash, ancestor.Hash) {
+		branch = append(branch, block)
+		var err error
+		if block, err = bc.GetBlock(block.PrevHash); err != nil {
+			return nil, err
+		}
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch, nil
+}
+
+// makes the branch ending with newTip the best chain. blocks of the current chain
+// after the fork point are disconnected and their transactions return to the mempool.
+// if any block of the new branch is invalid the current chain is restored and the block
+// is marked invalid together with the rest of the branch
+func (bc *Blockchain) reorganize(oldTipHash []byte, newTip *Block) error {
+	oldTip, err := bc.GetBlock(oldTipHash)
+	if err != nil {
+		return err
+	}
+	fork, err := bc.findForkPoint(oldTip, newTip)
+	if err != nil {
+		return err
+	}
+	disconnected, err := bc.getBranch(fork, oldTip)
+	if err != nil {
+		return err
+	}
+	connected, err := bc.getBranch(fork, newTip)
+	if err != nil {
+		return err
+	}
+	// branch can contain descendants of an invalid block which were stored before it was marked
+	for i, block := range connected {
+		if bc.invalid[string(block.Hash)] {
+			bc.markInvalid(connected[i:])
+			return ErrInvalidParent
+		}
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		err = bc.disconnectBlock(disconnected[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i, block := range connected {
+		validErr := ValidateBlock(bc, block)
+		err = validErr
+		if err == nil {
+			err = bc.connectBlock(block)
+		}
+		if err != nil {
+			if restoreErr := bc.restoreBranch(connected[:i], disconnected); restoreErr != nil {
+				return restoreErr
+			}
+			if validErr != nil {
+				bc.markInvalid(connected[i:])
+			}
+			return err
+		}
+	}
+	txs := []*Transaction{}
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				txs = append(txs, tx)
+			}
+		}
+	}
+	bc.mempool.Rebuild(txs)
+	return nil
+}
+
+// remembers stored blocks which can't be connected. merkle root of a stored block commits
+// to whole transactions, so a failure can't be caused by a changed body and hash is enough
+func (bc *Blockchain) markInvalid(blocks []*Block) {
+	for _, block := range blocks {
+		bc.invalid[string(block.Hash)] = true
+	}
+}
+
+// disconnects partially connected branch and connects back the old one
+func (bc *Blockchain) restoreBranch(connected, disconnected []*Block) error {
+	for i := len(connected) - 1; i >= 0; i-- {
+		err := bc.disconnectBlock(connected[i])
+		if err != nil {
+			return err
+		}
+	}
+	for _, block := range disconnected {
+		err := bc.connectBlock(block)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moves the tip to the parent of the tip block and reverts its utxo changes
+func (bc *Blockchain) disconnectBlock(block *Block) error {
+	if !bytes.Equal(bc.tip, block.Hash) {
+		return errors.New("BLOCK IS NOT THE TIP")
+	}
+	err := bc.utxoset.DisconnectBlock(block)
+	if err != nil {
+		return err
+	}
+	err = bc.db.UpdateLast(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	bc.setTip(block.PrevHash)
+	return nil
+}
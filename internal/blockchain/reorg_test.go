@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	database "bchain/internal/db"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// mines block on top of the parent which is not necessarily the tip, data makes coinbases of
+// blocks with the same parent different
+func mineOn(t *testing.T, bc *Blockchain, parent *Block, miner string, data string, txs ...*Transaction) *Block {
+	t.Helper()
+	header := parent.Header()
+	medianTime, err := medianTimePast(&header, func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase, err := NewCoinbaseTX(miner, data, parent.Height+1, bc.params.Subsidy(parent.Height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBlock(append([]*Transaction{coinbase}, txs...), parent.Hash, parent.Height+1, parent.Nbits, medianTime+1)
+}
+
+func TestReorganizeMarksInvalidBranch(t *testing.T) {
+	db, err := database.NewDb(filepath.Join(t.TempDir(), "blocks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(db, RegtestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	miner, err := w.Address(RegtestParams.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := bc.GetBlock(bc.genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := bc.MineBlock(miner, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tip := bc.getTip()
+
+	// spends unknown output, it is found only when the branch is connected
+	bad, err := NewTX([]TXInput{{TxID: make([]byte, 32), Vout: 0, Sequence: SequenceFinal}}, []TXOutput{*NewTXO(1, miner)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid := mineOn(t, bc, genesis, miner, "", bad)
+	child := mineOn(t, bc, invalid, miner, "")
+	sibling := mineOn(t, bc, invalid, miner, "sibling")
+	for _, b := range []*Block{invalid, child, sibling} {
+		if err := bc.AddBlock(b); err != nil {
+			t.Fatalf("side branch block: %s", err)
+		}
+	}
+	grandchild := mineOn(t, bc, child, miner, "")
+	if err := bc.AddBlock(grandchild); !errors.Is(err, ErrMissingOutput) {
+		t.Fatalf("reorganization to invalid branch: got %v, want %v", err, ErrMissingOutput)
+	}
+	if string(bc.getTip()) != string(tip) {
+		t.Fatal("tip changed after failed reorganization")
+	}
+	if err := bc.AddBlock(invalid); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("invalid block: got %v, want %v", err, ErrInvalidBlock)
+	}
+	if err := bc.AddBlock(mineOn(t, bc, grandchild, miner, "")); !errors.Is(err, ErrInvalidParent) {
+		t.Fatalf("block on invalid branch: got %v, want %v", err, ErrInvalidParent)
+	}
+	// sibling was stored before its parent was found invalid
+	if err := bc.AddBlock(mineOn(t, bc, sibling, miner, "")); !errors.Is(err, ErrInvalidParent) {
+		t.Fatalf("block on stored descendant of invalid block: got %v, want %v", err, ErrInvalidParent)
+	}
+	if string(bc.getTip()) != string(tip) {
+		t.Fatal("tip changed after block on invalid branch")
+	}
+}
@@ -0,0 +1,465 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+var ErrScriptFailed = errors.New("SCRIPT FAILED")
+
+// state of the script check of one transaction input
+type scriptEngine struct {
+	stack [][]byte
+	// branches of OP_IF the current opcode is in, opcodes are executed if all of them are taken
+	branches []bool
+	// opcodes counted against maxScriptOps in the current script
+	opCount int
+	tx      *Transaction
+	index   int
+	// locking script of the spent output, signatures commit to it
+	scriptCode []byte
+}
+
+// runs scriptSig of the input and then scriptPubKey of the spent output on the same stack.
+// scriptSig can only push data, the input is valid if the top of the stack is true at the end.
+// for pay to script hash output the redeem script pushed last is run on the rest of the stack
+func verifyScript(scriptSig []byte, scriptPubKey []byte, tx *Transaction, index int) error {
+	if !isPushOnly(scriptSig) {
+		return errors.New("SCRIPTSIG IS NOT PUSH ONLY")
+	}
+	e := &scriptEngine{tx: tx, index: index, scriptCode: scriptPubKey}
+	err := e.execute(scriptSig)
+	if err != nil {
+		return err
+	}
+	pushed := append([][]byte{}, e.stack...)
+	err = e.run(scriptPubKey)
+	if err != nil {
+		return err
+	}
+	if _, ok := extractP2SH(scriptPubKey); !ok {
+		return nil
+	}
+	redeemScript := pushed[len(pushed)-1]
+	e.stack = pushed[:len(pushed)-1]
+	e.scriptCode = redeemScript
+	return e.run(redeemScript)
+}
+
+// executes the script, top of the stack must be true after it
+func (e *scriptEngine) run(script []byte) error {
+	err := e.execute(script)
+	if err != nil {
+		return err
+	}
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+func (e *scriptEngine) push(data []byte) error {
+	if len(e.stack) >= maxStackSize {
+		return errors.New("SCRIPT STACK OVERFLOW")
+	}
+	e.stack = append(e.stack, data)
+	return nil
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, errors.New("SCRIPT STACK UNDERFLOW")
+	}
+	top := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	return top, nil
+}
+
+func (e *scriptEngine) popInt() (int64, error) {
+	data, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	return scriptNum(data, 4)
+}
+
+func (e *scriptEngine) popBool() (bool, error) {
+	data, err := e.pop()
+	if err != nil {
+		return false, err
+	}
+	return castToBool(data), nil
+}
+
+func (e *scriptEngine) execute(script []byte) error {
+	ops, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+	e.branches = nil
+	e.opCount = 0
+	for _, op := range ops {
+		err = e.step(op)
+		if err != nil {
+			return err
+		}
+	}
+	if len(e.branches) != 0 {
+		return errors.New("OP_IF WITHOUT OP_ENDIF")
+	}
+	return nil
+}
+
+func (e *scriptEngine) isExecuting() bool {
+	for _, taken := range e.branches {
+		if !taken {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *scriptEngine) step(op scriptOp) error {
+	if len(op.data) > maxScriptElementSize {
+		return errors.New("SCRIPT ELEMENT IS TOO BIG")
+	}
+	// opcodes of branches which are not taken are counted too
+	if !op.isPush() {
+		err := e.countOps(1)
+		if err != nil {
+			return err
+		}
+	}
+	switch op.opcode {
+	case OpIf, OpNotIf:
+		taken := false
+		if e.isExecuting() {
+			v, err := e.popBool()
+			if err != nil {
+				return err
+			}
+			taken = v == (op.opcode == OpIf)
+		}
+		e.branches = append(e.branches, taken)
+		return nil
+	case OpElse, OpEndIf:
+		if len(e.branches) == 0 {
+			return errors.New("OP_ELSE OR OP_ENDIF WITHOUT OP_IF")
+		}
+		if op.opcode == OpElse {
+			e.branches[len(e.branches)-1] = !e.branches[len(e.branches)-1]
+		} else {
+			e.branches = e.branches[:len(e.branches)-1]
+		}
+		return nil
+	}
+	if !e.isExecuting() {
+		return nil
+	}
+	switch {
+	case op.opcode <= OpPushData2:
+		return e.push(op.data)
+	case op.opcode >= Op1 && op.opcode <= Op16:
+		return e.push(encodeScriptNum(int64(op.opcode - Op1 + 1)))
+	}
+	switch op.opcode {
+	case OpVerify:
+		return e.verify()
+	case OpReturn:
+		return errors.New("OP_RETURN IN SCRIPT")
+	case OpDrop:
+		_, err := e.pop()
+		return err
+	case OpDup:
+		if len(e.stack) == 0 {
+			return errors.New("SCRIPT STACK UNDERFLOW")
+		}
+		return e.push(e.stack[len(e.stack)-1])
+	case OpSize:
+		if len(e.stack) == 0 {
+			return errors.New("SCRIPT STACK UNDERFLOW")
+		}
+		return e.push(encodeScriptNum(int64(len(e.stack[len(e.stack)-1]))))
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		err = e.push(encodeBool(bytes.Equal(a, b)))
+		if err != nil || op.opcode == OpEqual {
+			return err
+		}
+		return e.verify()
+	case OpSHA256:
+		data, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		return e.push(hash[:])
+	case OpCheckSig, OpCheckSigVerify:
+		err := e.checkSig()
+		if err != nil || op.opcode == OpCheckSig {
+			return err
+		}
+		return e.verify()
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		err := e.checkMultiSig()
+		if err != nil || op.opcode == OpCheckMultiSig {
+			return err
+		}
+		return e.verify()
+	case OpCheckLockTimeVerify:
+		return e.checkLockTime()
+	case OpCheckSequenceVerify:
+		return e.checkSequence()
+	}
+	return errors.New("UNKNOWN OPCODE")
+}
+
+func (e *scriptEngine) countOps(n int) error {
+	e.opCount += n
+	if e.opCount > maxScriptOps {
+		return errors.New("SCRIPT HAS TOO MANY OPCODES")
+	}
+	return nil
+}
+
+func (e *scriptEngine) verify() error {
+	ok, err := e.popBool()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+// <signature> <pubkey> -> <bool>
+func (e *scriptEngine) checkSig() error {
+	pubKey, err := e.pop()
+	if err != nil {
+		return err
+	}
+	signature, err := e.pop()
+	if err != nil {
+		return err
+	}
+	ok, err := e.checkSignature(signature, pubKey)
+	if err != nil {
+		return err
+	}
+	return e.push(encodeBool(ok))
+}
+
+// <sig 1> ... <sig m> <m> <pubkey 1> ... <pubkey n> <n> -> <bool>.
+// unlike bitcoin there is no extra dummy element, signatures must be in the order of keys
+func (e *scriptEngine) checkMultiSig() error {
+	n, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > maxMultiSigKeys {
+		return errors.New("INVALID NUMBER OF MULTISIG KEYS")
+	}
+	err = e.countOps(int(n))
+	if err != nil {
+		return err
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		pubKeys[i], err = e.pop()
+		if err != nil {
+			return err
+		}
+	}
+	m, err := e.popInt()
+	if err != nil {
+		return err
+	}
+	if m < 0 || m > n {
+		return errors.New("INVALID NUMBER OF MULTISIG SIGNATURES")
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		signatures[i], err = e.pop()
+		if err != nil {
+			return err
+		}
+	}
+	k := 0
+	for _, signature := range signatures {
+		matched := false
+		for k < len(pubKeys) && !matched {
+			matched, err = e.checkSignature(signature, pubKeys[k])
+			if err != nil {
+				return err
+			}
+			k++
+		}
+		if !matched {
+			return e.push(encodeBool(false))
+		}
+	}
+	return e.push(encodeBool(true))
+}
+
+// returns lock on top of the stack without removing it
+func (e *scriptEngine) peekLock() (int64, error) {
+	if len(e.stack) == 0 {
+		return 0, errors.New("SCRIPT STACK UNDERFLOW")
+	}
+	lock, err := scriptNum(e.stack[len(e.stack)-1], 5)
+	if err != nil {
+		return 0, err
+	}
+	if lock < 0 {
+		return 0, ErrScriptFailed
+	}
+	return lock, nil
+}
+
+// fails unless lock time of the transaction is a height or time of the same kind as the lock on
+// top of the stack and is not below it. lock time is enforced by consensus, so the output can't be
+// spent earlier. the value is left on the stack
+func (e *scriptEngine) checkLockTime() error {
+	lock, err := e.peekLock()
+	if err != nil {
+		return err
+	}
+	lockTime := e.tx.LockTime
+	if (uint64(lock) < LockTimeThreshold) != (lockTime < LockTimeThreshold) || lockTime < uint64(lock) {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+// fails unless relative lock of the input is of the same kind as the lock on top of the stack
+// and is not below it. lock with disabled flag makes it do nothing. the value is left on the stack
+func (e *scriptEngine) checkSequence() error {
+	lock, err := e.peekLock()
+	if err != nil {
+		return err
+	}
+	if uint32(lock)&SequenceLockDisabled != 0 {
+		return nil
+	}
+	sequence := e.tx.Vin[e.index].Sequence
+	if sequence&SequenceLockDisabled != 0 {
+		return ErrScriptFailed
+	}
+	kind := SequenceLockTime
+	if uint32(lock)&kind != sequence&kind || uint32(lock)&SequenceLockMask > sequence&SequenceLockMask {
+		return ErrScriptFailed
+	}
+	return nil
+}
+
+// malformed signatures and keys don't make the script invalid, they are just not valid for the input
+func (e *scriptEngine) checkSignature(signature []byte, pubKey []byte) (bool, error) {
+	key, ok := parsePubKey(pubKey)
+	if !ok || len(signature) != 65 {
+		return false, nil
+	}
+	hashType := signature[64]
+	if !isValidHashType(hashType) || hashType&^SigHashAnyoneCanPay == SigHashSingle && e.index >= len(e.tx.Vout) {
+		return false, nil
+	}
+	hash, err := e.tx.signatureHash(e.index, e.scriptCode, hashType)
+	if err != nil {
+		return false, err
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:64])
+	return ecdsa.Verify(key, hash, r, s), nil
+}
+
+// wallet keys are X and Y coordinates written one after another
+func parsePubKey(pubKey []byte) (*ecdsa.PublicKey, bool) {
+	if len(pubKey) == 0 || len(pubKey)%2 != 0 {
+		return nil, false
+	}
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(pubKey[:len(pubKey)/2])
+	y := new(big.Int).SetBytes(pubKey[len(pubKey)/2:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, false
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+}
+
+// empty data, zero and negative zero are false
+func castToBool(data []byte) bool {
+	for i, b := range data {
+		if b != 0 {
+			return i != len(data)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+func encodeBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// numbers on the stack are little endian with the sign in the highest bit of the last byte,
+// zero is empty data
+func encodeScriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+	result := []byte{}
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
+	}
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// decodes number which takes at most maxLen bytes, it must be minimally encoded
+func scriptNum(data []byte, maxLen int) (int64, error) {
+	if len(data) > maxLen {
+		return 0, errors.New("SCRIPT NUMBER IS TOO BIG")
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	last := data[len(data)-1]
+	if last&0x7f == 0 && (len(data) == 1 || data[len(data)-2]&0x80 == 0) {
+		return 0, errors.New("SCRIPT NUMBER IS NOT MINIMALLY ENCODED")
+	}
+	var n int64
+	for i, b := range data {
+		n |= int64(b) << (8 * i)
+	}
+	if last&0x80 != 0 {
+		return -(n &^ (int64(0x80) << (8 * (len(data) - 1)))), nil
+	}
+	return n, nil
+}
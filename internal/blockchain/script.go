@@ -0,0 +1,348 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// opcodes of the locking scripts, values are the same as in bitcoin script.
+// bytes 0x01-0x4b push that many following bytes
+const (
+	Op0                   byte = 0x00
+	OpPushData1           byte = 0x4c
+	OpPushData2           byte = 0x4d
+	Op1                   byte = 0x51
+	Op16                  byte = 0x60
+	OpIf                  byte = 0x63
+	OpNotIf               byte = 0x64
+	OpElse                byte = 0x67
+	OpEndIf               byte = 0x68
+	OpVerify              byte = 0x69
+	OpReturn              byte = 0x6a
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpSize                byte = 0x82
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpSHA256              byte = 0xa8
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+	OpCheckLockTimeVerify byte = 0xb1
+	OpCheckSequenceVerify byte = 0xb2
+)
+
+const (
+	maxScriptSize        = 10000
+	maxScriptElementSize = 520
+	maxStackSize         = 1000
+	// keys of multisig script are counted with OP_1-OP_16
+	maxMultiSigKeys = 16
+	// redeem script of p2sh is pushed as one element, keys take 65 bytes with the push,
+	// so 3 + 7*65 = 458 bytes fit into maxScriptElementSize and 8 keys don't
+	MaxMultisigAddressKeys = 7
+	// opcodes other than pushes in one script, keys of OP_CHECKMULTISIG are counted too,
+	// so it also limits number of signature checks
+	maxScriptOps = 201
+)
+
+var opNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpSize:                "OP_SIZE",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSHA256:              "OP_SHA256",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+var ErrBadScript = errors.New("INVALID SCRIPT")
+
+// parsed opcode, data is set for pushes
+type scriptOp struct {
+	opcode byte
+	data   []byte
+}
+
+func (op scriptOp) isPush() bool {
+	return op.opcode <= OpPushData2 || op.opcode >= Op1 && op.opcode <= Op16
+}
+
+// splits script into opcodes. length of OP_PUSHDATA is big endian as every integer of the chain
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, ErrBadScript
+	}
+	ops := []scriptOp{}
+	for len(script) > 0 {
+		opcode := script[0]
+		script = script[1:]
+		var n int
+		switch {
+		case opcode > Op0 && opcode < OpPushData1:
+			n = int(opcode)
+		case opcode == OpPushData1:
+			if len(script) < 1 {
+				return nil, ErrBadScript
+			}
+			n = int(script[0])
+			script = script[1:]
+		case opcode == OpPushData2:
+			if len(script) < 2 {
+				return nil, ErrBadScript
+			}
+			n = int(binary.BigEndian.Uint16(script))
+			script = script[2:]
+		}
+		if len(script) < n {
+			return nil, ErrBadScript
+		}
+		op := scriptOp{opcode: opcode}
+		if n > 0 {
+			op.data = script[:n]
+			script = script[n:]
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// appends opcodes and pushes to a script
+type ScriptBuilder struct {
+	buf bytes.Buffer
+}
+
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+func (b *ScriptBuilder) AddOp(opcode byte) *ScriptBuilder {
+	b.buf.WriteByte(opcode)
+	return b
+}
+
+// pushes data with the shortest push opcode
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	n := len(data)
+	switch {
+	case n == 0:
+		b.buf.WriteByte(Op0)
+	case n < int(OpPushData1):
+		b.buf.WriteByte(byte(n))
+	case n <= 0xff:
+		b.buf.WriteByte(OpPushData1)
+		b.buf.WriteByte(byte(n))
+	default:
+		var l [2]byte
+		binary.BigEndian.PutUint16(l[:], uint16(n))
+		b.buf.WriteByte(OpPushData2)
+		b.buf.Write(l[:])
+	}
+	b.buf.Write(data)
+	return b
+}
+
+// pushes number, small ones are pushed with OP_1-OP_16
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	if n > 0 && n <= 16 {
+		return b.AddOp(Op1 + byte(n-1))
+	}
+	return b.AddData(encodeScriptNum(n))
+}
+
+func (b *ScriptBuilder) Script() []byte {
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+// OP_DUP OP_SHA256 <pubkey hash> OP_EQUALVERIFY OP_CHECKSIG
+func NewP2PKHScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OpDup).AddOp(OpSHA256).AddData(pubKeyHash).
+		AddOp(OpEqualVerify).AddOp(OpCheckSig).Script()
+}
+
+// returns key hash of pay to pubkey hash script
+func extractP2PKH(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, false
+	}
+	return matchP2PKH(ops)
+}
+
+func matchP2PKH(ops []scriptOp) ([]byte, bool) {
+	if len(ops) != 5 {
+		return nil, false
+	}
+	if ops[0].opcode != OpDup || ops[1].opcode != OpSHA256 || len(ops[2].data) != 32 ||
+		ops[3].opcode != OpEqualVerify || ops[4].opcode != OpCheckSig {
+		return nil, false
+	}
+	return ops[2].data, true
+}
+
+// <lock> OP_CHECKLOCKTIMEVERIFY OP_DROP followed by pay to pubkey hash script. lockOp is
+// OP_CHECKLOCKTIMEVERIFY for absolute lock and OP_CHECKSEQUENCEVERIFY for relative one
+func NewTimelockedP2PKHScript(lockOp byte, lock int64, pubKeyHash []byte) []byte {
+	script := NewScriptBuilder().AddInt(lock).AddOp(lockOp).AddOp(OpDrop).Script()
+	return append(script, NewP2PKHScript(pubKeyHash)...)
+}
+
+// returns lock opcode, lock and key hash of timelocked pay to pubkey hash script
+func extractTimelockedP2PKH(script []byte) (byte, int64, []byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 8 || !ops[0].isPush() || ops[2].opcode != OpDrop {
+		return 0, 0, nil, false
+	}
+	lockOp := ops[1].opcode
+	if lockOp != OpCheckLockTimeVerify && lockOp != OpCheckSequenceVerify {
+		return 0, 0, nil, false
+	}
+	lock := int64(smallInt(ops[0]))
+	if lock < 0 {
+		lock, err = scriptNum(ops[0].data, 5)
+		if err != nil || lock < 0 {
+			return 0, 0, nil, false
+		}
+	}
+	pubKeyHash, ok := matchP2PKH(ops[3:])
+	if !ok {
+		return 0, 0, nil, false
+	}
+	return lockOp, lock, pubKeyHash, true
+}
+
+// OP_SHA256 <script hash> OP_EQUAL. scriptSig pushes the redeem script with the hash last,
+// it is run on the rest of the stack
+func NewP2SHScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OpSHA256).AddData(scriptHash).AddOp(OpEqual).Script()
+}
+
+// returns script hash of pay to script hash script
+func extractP2SH(script []byte) ([]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) != 3 {
+		return nil, false
+	}
+	if ops[0].opcode != OpSHA256 || len(ops[1].data) != 32 || ops[2].opcode != OpEqual {
+		return nil, false
+	}
+	return ops[1].data, true
+}
+
+// <m> <pubkey 1> ... <pubkey n> <n> OP_CHECKMULTISIG
+func NewMultisigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) == 0 || len(pubKeys) > maxMultiSigKeys || required <= 0 || required > len(pubKeys) {
+		return nil, errors.New("INVALID NUMBER OF MULTISIG KEYS")
+	}
+	b := NewScriptBuilder().AddInt(int64(required))
+	for _, pubKey := range pubKeys {
+		if _, ok := parsePubKey(pubKey); !ok {
+			return nil, errors.New("INVALID PUBLIC KEY")
+		}
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(len(pubKeys))).AddOp(OpCheckMultiSig).Script(), nil
+}
+
+// returns number of required signatures and keys of multisig script
+func extractMultisig(script []byte) (int, [][]byte, bool) {
+	ops, err := parseScript(script)
+	if err != nil || len(ops) < 4 || ops[len(ops)-1].opcode != OpCheckMultiSig {
+		return 0, nil, false
+	}
+	m, n := smallInt(ops[0]), smallInt(ops[len(ops)-2])
+	if m <= 0 || n != len(ops)-3 || m > n {
+		return 0, nil, false
+	}
+	pubKeys := [][]byte{}
+	for _, op := range ops[1 : len(ops)-2] {
+		if op.data == nil {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, op.data)
+	}
+	return m, pubKeys, true
+}
+
+// value of OP_1-OP_16, -1 for other opcodes
+func smallInt(op scriptOp) int {
+	if op.opcode < Op1 || op.opcode > Op16 {
+		return -1
+	}
+	return int(op.opcode-Op1) + 1
+}
+
+// <signature> <pubkey>
+func NewP2PKHScriptSig(signature []byte, pubKey []byte) []byte {
+	return NewScriptBuilder().AddData(signature).AddData(pubKey).Script()
+}
+
+func isPushOnly(script []byte) bool {
+	ops, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if !op.isPush() {
+			return false
+		}
+	}
+	return true
+}
+
+// returns data pushed by push only script
+func scriptPushes(script []byte) [][]byte {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil
+	}
+	pushes := [][]byte{}
+	for _, op := range ops {
+		if !op.isPush() {
+			return nil
+		}
+		pushes = append(pushes, op.data)
+	}
+	return pushes
+}
+
+// human readable form of the script, pushed data is written in hex
+func DisasmScript(script []byte) string {
+	ops, err := parseScript(script)
+	if err != nil {
+		return "[INVALID SCRIPT]"
+	}
+	words := make([]string, len(ops))
+	for i, op := range ops {
+		switch {
+		case op.opcode >= Op1 && op.opcode <= Op16:
+			words[i] = fmt.Sprintf("OP_%d", op.opcode-Op1+1)
+		case op.opcode > Op0 && op.opcode <= OpPushData2:
+			words[i] = hex.EncodeToString(op.data)
+		case opNames[op.opcode] != "":
+			words[i] = opNames[op.opcode]
+		default:
+			words[i] = fmt.Sprintf("OP_UNKNOWN%d", op.opcode)
+		}
+	}
+	return strings.Join(words, " ")
+}
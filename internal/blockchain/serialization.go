@@ -0,0 +1,310 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// canonical encoding used for ids, storage and the network. every integer is
+// big endian, int64 is written as its two's complement uint64, byte strings are
+// prefixed with uint64 length and lists with uint64 number of elements.
+//
+//	input:        txid, vout (8), scriptsig, sequence (4)
+//	output:       value (8), scriptpubkey
+//	transaction:  inputs, outputs, lock time (8)
+//	header:       version (4), timestamp (8), prev hash (32), merkle root (32), nbits (4), nonce (8), height (8)
+//	block:        header, transactions
+//
+// hashes in header have fixed size, so header always takes HeaderSize bytes, prev hash of
+// genesis is zero. serialized transactions and blocks start with SerializationVersion byte,
+// header is serialized alone without it.
+// ids and hashes are not encoded, they are computed again when data is decoded.
+// network messages carry blocks, transactions and headers in this encoding, but the
+// messages themselves are gob encoded, so only Go nodes can talk to each other
+const SerializationVersion byte = 1
+
+var ErrBadEncoding = errors.New("INVALID ENCODING")
+
+func writeUint(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeBool(buf *bytes.Buffer, v bool) {
+	if v {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeUint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// empty hash is written as zero hash
+func writeHash(buf *bytes.Buffer, hash []byte) {
+	var b [32]byte
+	copy(b[:], hash)
+	buf.Write(b[:])
+}
+
+// reads values written by write functions, first error stops reading
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) read(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.data)) < n {
+		d.err = ErrBadEncoding
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) readUint() uint64 {
+	b := d.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) readUint32() uint32 {
+	b := d.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) readByte() byte {
+	b := d.read(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readBool() bool {
+	v := d.readByte()
+	if v > 1 {
+		d.err = ErrBadEncoding
+	}
+	return v == 1
+}
+
+// empty byte strings are decoded as nil
+func (d *decoder) readVarBytes() []byte {
+	n := d.readUint()
+	if n == 0 {
+		return nil
+	}
+	return append([]byte{}, d.read(n)...)
+}
+
+// zero hash is decoded as empty one
+func (d *decoder) readHash() []byte {
+	b := d.read(32)
+	if b == nil || bytes.Equal(b, make([]byte, 32)) {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// number of list elements, each element takes at least minSize bytes
+func (d *decoder) readCount(minSize uint64) uint64 {
+	n := d.readUint()
+	if d.err == nil && n > uint64(len(d.data))/minSize {
+		d.err = ErrBadEncoding
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) readVersion() {
+	if d.readByte() != SerializationVersion && d.err == nil {
+		d.err = errors.New("UNKNOWN SERIALIZATION VERSION")
+	}
+}
+
+// all data must be read
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = ErrBadEncoding
+	}
+	return d.err
+}
+
+func (in *TXInput) encode(buf *bytes.Buffer) {
+	writeVarBytes(buf, in.TxID)
+	writeUint(buf, uint64(in.Vout))
+	writeVarBytes(buf, in.ScriptSig)
+	writeUint32(buf, in.Sequence)
+}
+
+func (d *decoder) input() TXInput {
+	return TXInput{
+		TxID:      d.readVarBytes(),
+		Vout:      int64(d.readUint()),
+		ScriptSig: d.readVarBytes(),
+		Sequence:  d.readUint32(),
+	}
+}
+
+func (out *TXOutput) encode(buf *bytes.Buffer) {
+	writeUint(buf, uint64(out.Value))
+	writeVarBytes(buf, out.ScriptPubKey)
+}
+
+func (d *decoder) output() TXOutput {
+	return TXOutput{
+		Value:        int64(d.readUint()),
+		ScriptPubKey: d.readVarBytes(),
+	}
+}
+
+func (tx *Transaction) encode(buf *bytes.Buffer) {
+	writeUint(buf, uint64(len(tx.Vin)))
+	for i := range tx.Vin {
+		tx.Vin[i].encode(buf)
+	}
+	writeUint(buf, uint64(len(tx.Vout)))
+	for i := range tx.Vout {
+		tx.Vout[i].encode(buf)
+	}
+	writeUint(buf, tx.LockTime)
+}
+
+// decodes transaction and computes its id
+func (d *decoder) transaction() *Transaction {
+	tx := new(Transaction)
+	vin := d.readCount(28)
+	for i := uint64(0); i < vin; i++ {
+		tx.Vin = append(tx.Vin, d.input())
+	}
+	vout := d.readCount(16)
+	for i := uint64(0); i < vout; i++ {
+		tx.Vout = append(tx.Vout, d.output())
+	}
+	tx.LockTime = d.readUint()
+	if d.err != nil {
+		return nil
+	}
+	tx.ID, d.err = tx.computeID()
+	return tx
+}
+
+func (b *Block) encode(buf *bytes.Buffer) {
+	buf.Write(b.GetData())
+	writeUint(buf, uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		tx.encode(buf)
+	}
+}
+
+// decodes header and computes its hash
+func (d *decoder) header() BlockHeader {
+	h := BlockHeader{
+		Version:    d.readUint32(),
+		Timestamp:  int64(d.readUint()),
+		PrevHash:   d.readHash(),
+		MerkleRoot: d.readHash(),
+		Nbits:      d.readUint32(),
+		Nonce:      d.readUint(),
+		Height:     d.readUint(),
+	}
+	h.Hash = h.computeHash()
+	return h
+}
+
+// decodes block and computes ids of its transactions and its hash
+func (d *decoder) block() *Block {
+	b := &Block{BlockHeader: d.header()}
+	n := d.readCount(24)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		b.Transactions = append(b.Transactions, d.transaction())
+	}
+	if d.err != nil {
+		return nil
+	}
+	return b
+}
+
+func (txos *TXOutputs) encode(buf *bytes.Buffer) {
+	writeUint(buf, txos.Height)
+	writeBool(buf, txos.Coinbase)
+	indexes := make([]int64, 0, len(txos.Outputs))
+	for index := range txos.Outputs {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	writeUint(buf, uint64(len(indexes)))
+	for _, index := range indexes {
+		out := txos.Outputs[index]
+		writeUint(buf, uint64(index))
+		out.encode(buf)
+	}
+}
+
+func (d *decoder) outputs() *TXOutputs {
+	txos := &TXOutputs{Outputs: map[int64]TXOutput{}}
+	txos.Height = d.readUint()
+	txos.Coinbase = d.readBool()
+	n := d.readCount(24)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		index := int64(d.readUint())
+		txos.Outputs[index] = d.output()
+	}
+	return txos
+}
+
+func (undo *BlockUndo) encode(buf *bytes.Buffer) {
+	writeUint(buf, uint64(len(undo.Spent)))
+	for _, spent := range undo.Spent {
+		writeVarBytes(buf, spent.TxID)
+		writeUint(buf, uint64(spent.Vout))
+		spent.Output.encode(buf)
+		writeUint(buf, spent.Height)
+		writeBool(buf, spent.Coinbase)
+	}
+}
+
+func (d *decoder) undo() *BlockUndo {
+	undo := new(BlockUndo)
+	n := d.readCount(41)
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		undo.Spent = append(undo.Spent, SpentOutput{
+			TxID:     d.readVarBytes(),
+			Vout:     int64(d.readUint()),
+			Output:   d.output(),
+			Height:   d.readUint(),
+			Coinbase: d.readBool(),
+		})
+	}
+	return undo
+}
+
+// writes version byte and the value
+func serialize(encode func(*bytes.Buffer)) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(SerializationVersion)
+	encode(buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testHeader() BlockHeader {
+	return BlockHeader{
+		Version:    1,
+		Timestamp:  0x0102030405060708,
+		PrevHash:   bytes.Repeat([]byte{0x11}, 32),
+		MerkleRoot: bytes.Repeat([]byte{0x22}, 32),
+		Nbits:      0x207fffff,
+		Nonce:      42,
+		Height:     7,
+	}
+}
+
+func testTransaction() *Transaction {
+	tx := &Transaction{
+		Vin:      []TXInput{{TxID: []byte{0xaa, 0xbb}, Vout: 1, ScriptSig: []byte{0x51}, Sequence: 0xfffffffe}},
+		Vout:     []TXOutput{{Value: 5000, ScriptPubKey: []byte{0x76, 0xa9}}},
+		LockTime: 100,
+	}
+	tx.ID, _ = tx.computeID()
+	return tx
+}
+
+func testBlock(t *testing.T) *Block {
+	t.Helper()
+	coinbase, err := NewTX([]TXInput{coinbaseInput(1, "test")}, []TXOutput{{Value: 50, ScriptPubKey: []byte{0x51}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &Block{BlockHeader: testHeader(), Transactions: []*Transaction{coinbase, testTransaction()}}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.computeHash()
+	return block
+}
+
+func TestHeaderGolden(t *testing.T) {
+	header := testHeader()
+	want := mustDecodeHex(t,
+		"00000001",
+		"0102030405060708",
+		strings.Repeat("11", 32),
+		strings.Repeat("22", 32),
+		"207fffff",
+		"000000000000002a",
+		"0000000000000007",
+	)
+	got, err := header.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("header encoding\n got %x\nwant %x", got, want)
+	}
+	if len(got) != HeaderSize {
+		t.Fatalf("header size %d, want %d", len(got), HeaderSize)
+	}
+	decoded, err := DeserializeHeader(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Hash = header.computeHash()
+	if decoded.Timestamp != header.Timestamp || decoded.Nonce != header.Nonce ||
+		decoded.Height != header.Height || !bytes.Equal(decoded.Hash, header.Hash) {
+		t.Fatalf("decoded header %+v, want %+v", decoded, header)
+	}
+}
+
+func TestTransactionGolden(t *testing.T) {
+	want := mustDecodeHex(t,
+		"01",
+		"0000000000000001",
+		"0000000000000002", "aabb",
+		"0000000000000001",
+		"0000000000000001", "51",
+		"fffffffe",
+		"0000000000000001",
+		"0000000000001388",
+		"0000000000000002", "76a9",
+		"0000000000000064",
+	)
+	tx := testTransaction()
+	got, err := tx.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("transaction encoding\n got %x\nwant %x", got, want)
+	}
+	decoded, err := DeserializeTransaction(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded.ID, tx.ID) {
+		t.Fatalf("decoded id %x, want %x", decoded.ID, tx.ID)
+	}
+}
+
+func TestTXOutputsGolden(t *testing.T) {
+	txos := &TXOutputs{
+		Outputs:  map[int64]TXOutput{2: {Value: 1, ScriptPubKey: []byte{0x00}}, 0: {Value: 3}},
+		Height:   9,
+		Coinbase: true,
+	}
+	want := mustDecodeHex(t,
+		"01",
+		"0000000000000009",
+		"01",
+		"0000000000000002",
+		"0000000000000000", "0000000000000003", "0000000000000000",
+		"0000000000000002", "0000000000000001", "0000000000000001", "00",
+	)
+	got, err := txos.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("outputs encoding\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	block := testBlock(t)
+	data, err := block.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := decoded.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) || !bytes.Equal(decoded.Hash, block.Hash) {
+		t.Fatal("block changed after round trip")
+	}
+	for i, tx := range decoded.Transactions {
+		if !bytes.Equal(tx.ID, block.Transactions[i].ID) {
+			t.Fatalf("transaction %d id %x, want %x", i, tx.ID, block.Transactions[i].ID)
+		}
+	}
+
+	txos := &TXOutputs{Outputs: map[int64]TXOutput{5: {Value: 7, ScriptPubKey: []byte{1, 2, 3}}}, Height: 3}
+	data, err = txos.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedTXOs, err := DeserializeTXO(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decodedTXOs.Height != 3 || decodedTXOs.Coinbase || len(decodedTXOs.Outputs) != 1 ||
+		!bytes.Equal(decodedTXOs.Outputs[5].ScriptPubKey, []byte{1, 2, 3}) {
+		t.Fatalf("decoded outputs %+v, want %+v", decodedTXOs, txos)
+	}
+}
+
+// every prefix of the encoding and the encoding with extra byte must be rejected
+func TestMalformedInput(t *testing.T) {
+	block, err := testBlock(t).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := testTransaction().Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txos, err := (&TXOutputs{Outputs: map[int64]TXOutput{0: {Value: 1}}, Height: 1}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		decode func([]byte) error
+	}{
+		{"block", block, func(data []byte) error { _, err := DeserializeBlock(data); return err }},
+		{"transaction", tx, func(data []byte) error { _, err := DeserializeTransaction(data); return err }},
+		{"outputs", txos, func(data []byte) error { _, err := DeserializeTXO(data); return err }},
+	}
+	for _, test := range tests {
+		for n := 0; n < len(test.data); n++ {
+			if test.decode(test.data[:n]) == nil {
+				t.Fatalf("%s truncated to %d bytes is decoded", test.name, n)
+			}
+		}
+		if test.decode(append(append([]byte{}, test.data...), 0)) == nil {
+			t.Fatalf("%s with trailing byte is decoded", test.name)
+		}
+		bad := append([]byte{}, test.data...)
+		bad[0] = SerializationVersion + 1
+		if test.decode(bad) == nil {
+			t.Fatalf("%s with unknown version is decoded", test.name)
+		}
+	}
+	// count of inputs which can't fit into the data
+	huge := mustDecodeHex(t, "01", "ffffffffffffffff")
+	if _, err := DeserializeTransaction(huge); err == nil {
+		t.Fatal("transaction with huge input count is decoded")
+	}
+}
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+// signature hash types select parts of the transaction the signature commits to,
+// the type is the last byte of the signature
+const (
+	// all inputs and outputs
+	SigHashAll byte = 0x01
+	// no outputs, sequences of other inputs can be changed
+	SigHashNone byte = 0x02
+	// only the output with the index of the input, sequences of other inputs can be changed
+	SigHashSingle byte = 0x03
+	// combined with one of the above, other inputs are not signed and can be added
+	SigHashAnyoneCanPay byte = 0x80
+)
+
+// parses hash type written as all, none or single, optionally followed by |anyonecanpay
+func ParseSigHashType(name string) (byte, error) {
+	base, modifier, combined := strings.Cut(strings.ToLower(name), "|")
+	var hashType byte
+	switch base {
+	case "all":
+		hashType = SigHashAll
+	case "none":
+		hashType = SigHashNone
+	case "single":
+		hashType = SigHashSingle
+	default:
+		return 0, errors.New("UNKNOWN SIGNATURE HASH TYPE")
+	}
+	if combined {
+		if modifier != "anyonecanpay" {
+			return 0, errors.New("UNKNOWN SIGNATURE HASH TYPE")
+		}
+		hashType |= SigHashAnyoneCanPay
+	}
+	return hashType, nil
+}
+
+func isValidHashType(hashType byte) bool {
+	base := hashType &^ SigHashAnyoneCanPay
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+// hash signed for the input, scriptSig of the input is replaced by the script of the spent output
+// and other scriptSigs are cleared. inputs and outputs not covered by the hash type are removed,
+// outputs before the signed one of SIGHASH_SINGLE are replaced with empty ones of value -1.
+// hash type is appended to the encoding, so it can't be changed without breaking the signature
+func (tx *Transaction) signatureHash(index int, scriptCode []byte, hashType byte) ([]byte, error) {
+	if index < 0 || index >= len(tx.Vin) {
+		return nil, errors.New("INVALID INPUT INDEX")
+	}
+	if !isValidHashType(hashType) {
+		return nil, errors.New("INVALID SIGNATURE HASH TYPE")
+	}
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[index].ScriptSig = scriptCode
+	switch hashType &^ SigHashAnyoneCanPay {
+	case SigHashNone:
+		txCopy.Vout = nil
+		txCopy.clearSequences(index)
+	case SigHashSingle:
+		if index >= len(txCopy.Vout) {
+			return nil, errors.New("SIGHASH_SINGLE INPUT HAS NO OUTPUT")
+		}
+		txCopy.Vout = txCopy.Vout[:index+1]
+		for i := 0; i < index; i++ {
+			txCopy.Vout[i] = TXOutput{Value: -1}
+		}
+		txCopy.clearSequences(index)
+	}
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Vin = []TXInput{txCopy.Vin[index]}
+	}
+	hash := sha256.Sum256(append(serialize(txCopy.encode), hashType))
+	return hash[:], nil
+}
+
+// sets sequences of inputs other than the signed one to zero
+func (tx *Transaction) clearSequences(index int) {
+	for i := range tx.Vin {
+		if i != index {
+			tx.Vin[i].Sequence = 0
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package blockchain
+
+import "testing"
+
+// two wallets and transaction paying 10 to the first one and 20 to the second one
+func sighashFixture(t *testing.T) (*Wallet, *Wallet, *Transaction, map[string]Transaction) {
+	t.Helper()
+	a, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrA, err := a.Address(RegtestParams.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrB, err := b.Address(RegtestParams.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding, err := NewTX([]TXInput{coinbaseInput(1, "funding")}, []TXOutput{*NewTXO(10, addrA), *NewTXO(20, addrB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b, funding, map[string]Transaction{string(funding.ID): *funding}
+}
+
+// transaction spending the first output of funding to the wallet
+func spendFirst(t *testing.T, w *Wallet, funding *Transaction, hashType byte) *Transaction {
+	t.Helper()
+	addr, err := w.Address(RegtestParams.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{
+		Vin:  []TXInput{{TxID: funding.ID, Vout: 0, Sequence: SequenceFinal}},
+		Vout: []TXOutput{*NewTXO(9, addr)},
+	}
+	prevTXs := map[string]Transaction{string(funding.ID): *funding}
+	err = tx.SignInput(0, *w, prevTXs, hashType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func verify(t *testing.T, tx *Transaction, prevTXs map[string]Transaction) bool {
+	t.Helper()
+	ok, err := tx.Verify(prevTXs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestAnyoneCanPayAllowsAddingInput(t *testing.T) {
+	for _, test := range []struct {
+		hashType byte
+		valid    bool
+	}{
+		{SigHashAll | SigHashAnyoneCanPay, true},
+		{SigHashAll, false},
+	} {
+		a, b, funding, prevTXs := sighashFixture(t)
+		tx := spendFirst(t, a, funding, test.hashType)
+		if !verify(t, tx, prevTXs) {
+			t.Fatalf("hash type %x: signed transaction is not valid", test.hashType)
+		}
+		tx.Vin = append(tx.Vin, TXInput{TxID: funding.ID, Vout: 1, Sequence: SequenceFinal})
+		err := tx.SignInput(1, *b, prevTXs, SigHashAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verify(t, tx, prevTXs) != test.valid {
+			t.Fatalf("hash type %x: transaction with added input valid %t, want %t", test.hashType, !test.valid, test.valid)
+		}
+	}
+}
+
+func TestSingleAllowsAddingOutput(t *testing.T) {
+	for _, test := range []struct {
+		hashType byte
+		valid    bool
+	}{
+		{SigHashSingle, true},
+		{SigHashSingle | SigHashAnyoneCanPay, true},
+		{SigHashAll, false},
+	} {
+		a, b, funding, prevTXs := sighashFixture(t)
+		tx := spendFirst(t, a, funding, test.hashType)
+		addrB, err := b.Address(RegtestParams.AddressVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.Vout = append(tx.Vout, *NewTXO(1, addrB))
+		if verify(t, tx, prevTXs) != test.valid {
+			t.Fatalf("hash type %x: transaction with added output valid %t, want %t", test.hashType, !test.valid, test.valid)
+		}
+		// the signed output itself can't be changed
+		tx.Vout[0].Value = 5
+		if verify(t, tx, prevTXs) {
+			t.Fatalf("hash type %x: transaction with changed signed output is valid", test.hashType)
+		}
+	}
+}
+
+func TestChangedHashTypeBreaksSignature(t *testing.T) {
+	for _, test := range []struct {
+		signed, changed byte
+	}{
+		{SigHashAll, SigHashAll | SigHashAnyoneCanPay},
+		{SigHashAll, SigHashNone},
+		{SigHashSingle, SigHashAll},
+		{SigHashNone | SigHashAnyoneCanPay, SigHashNone},
+	} {
+		a, _, funding, prevTXs := sighashFixture(t)
+		tx := spendFirst(t, a, funding, test.signed)
+		pushes := scriptPushes(tx.Vin[0].ScriptSig)
+		signature := append([]byte{}, pushes[0]...)
+		signature[len(signature)-1] = test.changed
+		tx.Vin[0].ScriptSig = NewP2PKHScriptSig(signature, pushes[1])
+		if verify(t, tx, prevTXs) {
+			t.Fatalf("signature of hash type %x is valid with hash type %x", test.signed, test.changed)
+		}
+	}
+}
+
+func TestParseSigHashType(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		hashType byte
+		valid    bool
+	}{
+		{"all", SigHashAll, true},
+		{"NONE", SigHashNone, true},
+		{"single|anyonecanpay", SigHashSingle | SigHashAnyoneCanPay, true},
+		{"all|ANYONECANPAY", SigHashAll | SigHashAnyoneCanPay, true},
+		{"anyonecanpay", 0, false},
+		{"all|none", 0, false},
+		{"", 0, false},
+	} {
+		hashType, err := ParseSigHashType(test.name)
+		if (err == nil) != test.valid || hashType != test.hashType {
+			t.Fatalf("%q parsed to %x, %v", test.name, hashType, err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+)
+
+// transaction with proof that it is in the block
+type TransactionProof struct {
+	Transaction *Transaction
+	BlockHash   []byte
+	Proof       *MerkleProof
+}
+
+// output of a light client wallet transaction
+type walletOutput struct {
+	TxID   []byte
+	Vout   int64
+	Output TXOutput
+	// height of the block containing the transaction
+	Height   uint64
+	Coinbase bool
+}
+
+// checks if the output is mature and its time locks are reached in block with spendHeight,
+// medianTime is of the parent of the block
+func (out *walletOutput) isSpendable(spendHeight uint64, medianTime int64, maturity uint64) bool {
+	txos := TXOutputs{Height: out.Height, Coinbase: out.Coinbase}
+	return txos.IsMature(spendHeight, maturity) && out.Output.IsUnlocked(out.Height, spendHeight, medianTime)
+}
+
+// returns transactions of the main chain which pay to one of the keys or spend its outputs
+func (bc *Blockchain) FindTransactionProofs(pubKeyHashes [][]byte) ([]TransactionProof, error) {
+	proofs := []TransactionProof{}
+	bci := bc.Iterator()
+	for bci.Next() {
+		block := bci.Block()
+		for _, tx := range block.Transactions {
+			if !isRelevant(tx, pubKeyHashes) {
+				continue
+			}
+			proof, err := block.MerkleProof(tx)
+			if err != nil {
+				return nil, err
+			}
+			proofs = append(proofs, TransactionProof{Transaction: tx, BlockHash: block.Hash, Proof: proof})
+		}
+	}
+	return proofs, nil
+}
+
+func isRelevant(tx *Transaction, pubKeyHashes [][]byte) bool {
+	for _, keyHash := range pubKeyHashes {
+		for _, out := range tx.Vout {
+			if out.IsLockedWith(keyHash) {
+				return true
+			}
+		}
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			if in.IsUsesKey(keyHash) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checks proofs against the best header chain and replaces stored wallet transactions with them.
+// nothing is stored if any proof is invalid
+func (hc *HeaderChain) UpdateWallet(proofs []TransactionProof) error {
+	for _, p := range proofs {
+		inBest, err := hc.IsInBestChain(p.BlockHash)
+		if err != nil {
+			return err
+		}
+		if !inBest {
+			return errors.New("BLOCK OF TRANSACTION IS NOT IN BEST CHAIN")
+		}
+		header, err := hc.GetHeader(p.BlockHash)
+		if err != nil {
+			return err
+		}
+		id, err := p.Transaction.computeID()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(id, p.Transaction.ID) || p.Proof == nil || !header.HasTransaction(p.Transaction, p.Proof) {
+			return errors.New("INVALID MERKLE PROOF")
+		}
+	}
+	err := hc.db.ClearWalletTxs()
+	if err != nil {
+		return err
+	}
+	for _, p := range proofs {
+		serialized, err := p.Transaction.Serialize()
+		if err != nil {
+			return err
+		}
+		err = hc.db.AddWalletTx(p.Transaction.ID, serialized, p.BlockHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns stored wallet transactions which are in the best chain with heights of their blocks
+func (hc *HeaderChain) walletTransactions() (map[string]*Transaction, map[string]uint64, error) {
+	txs := map[string]*Transaction{}
+	heights := map[string]uint64{}
+	iter, err := hc.db.WalletTxsIterator()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	for iter.Next() {
+		elem := iter.Get()
+		// wallet can be behind headers after a reorganization until the next sync
+		inBest, err := hc.IsInBestChain(elem.BlockHash)
+		if err != nil || !inBest {
+			continue
+		}
+		header, err := hc.GetHeader(elem.BlockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		tx, err := DeserializeTransaction(elem.Tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		txs[string(tx.ID)] = tx
+		heights[string(tx.ID)] = header.Height
+	}
+	return txs, heights, nil
+}
+
+// returns outputs locked with the key which are not spent by wallet transactions
+func (hc *HeaderChain) unspentOutputs(pubKeyHash []byte) ([]walletOutput, map[string]*Transaction, error) {
+	txs, heights, err := hc.walletTransactions()
+	if err != nil {
+		return nil, nil, err
+	}
+	spent := map[string]bool{}
+	for _, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Vin {
+			spent[outpointKey(in.TxID, in.Vout)] = true
+		}
+	}
+	unspent := []walletOutput{}
+	for id, tx := range txs {
+		for i, out := range tx.Vout {
+			if !out.IsLockedWith(pubKeyHash) || spent[outpointKey(tx.ID, int64(i))] {
+				continue
+			}
+			unspent = append(unspent, walletOutput{
+				TxID:     tx.ID,
+				Vout:     int64(i),
+				Output:   out,
+				Height:   heights[id],
+				Coinbase: tx.IsCoinbase(),
+			})
+		}
+	}
+	return unspent, txs, nil
+}
+
+// returns sums of proven outputs locked with the key which can be spent in the next block
+// and of coinbase outputs which are not mature yet and timelocked outputs
+func (hc *HeaderChain) Balance(pubKeyHash []byte) (int64, int64, error) {
+	unspent, _, err := hc.unspentOutputs(pubKeyHash)
+	if err != nil {
+		return 0, 0, err
+	}
+	medianTime, err := medianTimePast(hc.tip, hc.GetHeader)
+	if err != nil {
+		return 0, 0, err
+	}
+	var balance, immature int64
+	for _, out := range unspent {
+		if out.isSpendable(hc.tip.Height+1, medianTime, hc.params.CoinbaseMaturity) {
+			balance += out.Output.Value
+		} else {
+			immature += out.Output.Value
+		}
+	}
+	return balance, immature, nil
+}
+
+// creates transaction spending proven outputs of the wallet
+func (hc *HeaderChain) NewTransaction(from string, to string, amount int64, fee int64, locks PaymentLocks, hashType byte) (*Transaction, error) {
+	if amount <= 0 || fee < 0 {
+		return nil, errors.New("INVALID AMOUNT OR FEE")
+	}
+	err := hc.params.CheckAddress(to)
+	if err != nil {
+		return nil, err
+	}
+	wallets, err := NewWallets(hc.params.WalletFile)
+	if err != nil {
+		return nil, err
+	}
+	wallet := wallets.GetWallet(from)
+	pubKeyHash, err := ExtractPubKeyHash(from)
+	if err != nil {
+		return nil, err
+	}
+	unspent, txs, err := hc.unspentOutputs(pubKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	medianTime, err := medianTimePast(hc.tip, hc.GetHeader)
+	if err != nil {
+		return nil, err
+	}
+	var bal int64
+	txOuts := map[string][]int64{}
+	for _, out := range unspent {
+		if bal >= amount+fee {
+			break
+		}
+		if !out.isSpendable(hc.tip.Height+1, medianTime, hc.params.CoinbaseMaturity) {
+			continue
+		}
+		bal += out.Output.Value
+		txOuts[string(out.TxID)] = append(txOuts[string(out.TxID)], out.Vout)
+	}
+	tx, err := newSpendingTX(from, to, amount, fee, bal, txOuts, locks)
+	if err != nil {
+		return nil, err
+	}
+	prevTXs := map[string]Transaction{}
+	for _, in := range tx.Vin {
+		prevTXs[string(in.TxID)] = *txs[string(in.TxID)]
+	}
+	err = tx.Sign(wallet, prevTXs, hashType)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
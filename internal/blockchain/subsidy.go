@@ -0,0 +1,82 @@
+package blockchain
+
+// no output value, sum of values or supply can exceed it, so sums of values can't overflow
+const MaxMoney int64 = 21000000 * 100000000
+
+func isMoneyRange(value int64) bool {
+	return value >= 0 && value <= MaxMoney
+}
+
+// adds value to sum, false is returned if value or the result is out of money range
+func addMoney(sum int64, value int64) (int64, bool) {
+	if !isMoneyRange(sum) || !isMoneyRange(value) || sum+value > MaxMoney {
+		return 0, false
+	}
+	return sum + value, true
+}
+
+type Supply struct {
+	// height of the tip
+	Height uint64
+	// sum of unspent outputs
+	Unspent int64
+	// coins which could be created by blocks up to the tip
+	Scheduled int64
+	// coins which will ever be created
+	Max int64
+}
+
+// returns coins created by block with the height in addition to fees
+func (p *ChainParams) Subsidy(height uint64) int64 {
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return p.InitialSubsidy >> halvings
+}
+
+// returns genesis allocations and sum of subsidies of blocks after genesis up to the height
+func (p *ChainParams) ScheduledSupply(height uint64) int64 {
+	total := p.Genesis.Value() - p.Subsidy(0)
+	blocks := height + 1
+	for halvings := uint64(0); blocks > 0 && halvings < 63; halvings++ {
+		n := p.HalvingInterval
+		if blocks < n {
+			n = blocks
+		}
+		total += int64(n) * (p.InitialSubsidy >> halvings)
+		blocks -= n
+	}
+	return total
+}
+
+func (p *ChainParams) MaxSupply() int64 {
+	total := p.Genesis.Value() - p.Subsidy(0)
+	for halvings := uint64(0); halvings < 63; halvings++ {
+		total += int64(p.HalvingInterval) * (p.InitialSubsidy >> halvings)
+	}
+	return total
+}
+
+// compares coins in utxo set with the emission schedule. unspent coins can be
+// less than scheduled if miners did not claim the whole subsidy, but never more
+func (bc *Blockchain) Supply() (*Supply, error) {
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	unspent, err := bc.utxoset.TotalValue()
+	if err != nil {
+		return nil, err
+	}
+	return &Supply{
+		Height:    height,
+		Unspent:   unspent,
+		Scheduled: bc.params.ScheduledSupply(height),
+		Max:       bc.params.MaxSupply(),
+	}, nil
+}
+
+func (s *Supply) IsValid() bool {
+	return s.Unspent <= s.Scheduled && s.Scheduled <= s.Max
+}
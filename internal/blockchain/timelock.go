@@ -0,0 +1,230 @@
+package blockchain
+
+import (
+	"errors"
+	"sort"
+)
+
+// lock times below it are heights, others are unix timestamps
+const LockTimeThreshold = 500000000
+
+// sequence of an input is its relative lock: number of blocks or of 512 second
+// intervals which must pass after the spent output is included in a block
+const (
+	// input without relative lock
+	SequenceFinal uint32 = 0xffffffff
+	// relative lock is not enforced if set
+	SequenceLockDisabled uint32 = 1 << 31
+	// lock is in 512 second intervals instead of blocks
+	SequenceLockTime uint32 = 1 << 22
+	SequenceLockMask uint32 = 0xffff
+	// seconds of one interval of time based relative lock as a power of two
+	sequenceTimeGranularity = 9
+)
+
+// number of blocks median time past is taken from
+const medianTimeBlocks = 11
+
+// locks of a payment made by the wallet
+type PaymentLocks struct {
+	// lock time of the transaction
+	LockTime uint64
+	// recipient can spend the payment from this height or median time
+	Until uint64
+	// recipient can spend the payment this many blocks after the transaction is included
+	Blocks uint32
+}
+
+var (
+	ErrNonFinalTransaction = errors.New("TRANSACTION LOCK TIME IS NOT REACHED")
+	ErrSequenceLock        = errors.New("RELATIVE LOCK OF INPUT IS NOT REACHED")
+)
+
+// median timestamp of the header and its ancestors. time locks are compared with it
+// instead of block timestamps, so miners can't move it much
+func medianTimePast(header *BlockHeader, getHeader func([]byte) (*BlockHeader, error)) (int64, error) {
+	timestamps := []int64{}
+	for i := 0; i < medianTimeBlocks; i++ {
+		timestamps = append(timestamps, header.Timestamp)
+		if len(header.PrevHash) == 0 {
+			break
+		}
+		prev, err := getHeader(header.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		header = prev
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// returns ancestor of the header with the height
+func ancestorAt(header *BlockHeader, height uint64, getHeader func([]byte) (*BlockHeader, error)) (*BlockHeader, error) {
+	for header.Height > height {
+		prev, err := getHeader(header.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		header = prev
+	}
+	return header, nil
+}
+
+// lock time is the earliest height or median time past of the parent
+// of the block which can include the transaction, zero means no lock
+func (tx *Transaction) IsFinal(height uint64, medianTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return tx.LockTime <= height
+	}
+	return int64(tx.LockTime) <= medianTime
+}
+
+// checks lock time and relative locks of the transaction for block with the height on top of parent.
+// outputs of pending transactions are included in the same block
+func (bc *Blockchain) checkLocks(tx *Transaction, pending map[string]*Transaction, height uint64, parent *BlockHeader) error {
+	getHeader := func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, nil)
+	}
+	medianTime, err := medianTimePast(parent, getHeader)
+	if err != nil {
+		return err
+	}
+	if !tx.IsFinal(height, medianTime) {
+		return ErrNonFinalTransaction
+	}
+	for _, vin := range tx.Vin {
+		if vin.Sequence&SequenceLockDisabled != 0 {
+			continue
+		}
+		outHeight := height
+		if _, ok := pending[string(vin.TxID)]; !ok {
+			outs, err := bc.utxoset.getOutputs(vin.TxID)
+			if err != nil {
+				return err
+			}
+			outHeight = outs.Height
+		}
+		lock := uint64(vin.Sequence & SequenceLockMask)
+		if vin.Sequence&SequenceLockTime == 0 {
+			if height < outHeight+lock {
+				return ErrSequenceLock
+			}
+			continue
+		}
+		// time is counted from median time past of the block before the one with the output
+		base := parent
+		if outHeight > 0 {
+			base, err = ancestorAt(parent, outHeight-1, getHeader)
+			if err != nil {
+				return err
+			}
+		}
+		baseTime, err := medianTimePast(base, getHeader)
+		if err != nil {
+			return err
+		}
+		if medianTime < baseTime+int64(lock<<sequenceTimeGranularity) {
+			return ErrSequenceLock
+		}
+	}
+	return nil
+}
+
+func (bc *Blockchain) tipHeader() (*BlockHeader, error) {
+	lastHash, err := bc.db.GetLast()
+	if err != nil {
+		return nil, err
+	}
+	return bc.getHeader(lastHash, nil)
+}
+
+// median time past of the tip, a transaction of the next block is compared with it
+func (bc *Blockchain) tipMedianTime() (int64, error) {
+	tip, err := bc.tipHeader()
+	if err != nil {
+		return 0, err
+	}
+	return medianTimePast(tip, func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, nil)
+	})
+}
+
+// checks if locks of timelocked pay to pubkey hash output included in block with outHeight
+// allow to spend it in block with spendHeight. medianTime is of the parent of that block.
+// time based relative locks are not tracked by wallets, such outputs are always locked
+func (out *TXOutput) IsUnlocked(outHeight uint64, spendHeight uint64, medianTime int64) bool {
+	lockOp, lock, _, ok := extractTimelockedP2PKH(out.ScriptPubKey)
+	if !ok {
+		return true
+	}
+	if lockOp == OpCheckSequenceVerify {
+		if uint32(lock)&SequenceLockDisabled != 0 {
+			return true
+		}
+		return uint32(lock)&SequenceLockTime == 0 && spendHeight >= outHeight+uint64(uint32(lock)&SequenceLockMask)
+	}
+	if lock < LockTimeThreshold {
+		return uint64(lock) <= spendHeight
+	}
+	return lock <= medianTime
+}
+
+// sets lock time and sequences needed to spend timelocked pay to pubkey hash outputs.
+// id is computed again as locks are part of it
+func (tx *Transaction) setLocks(prevTXs map[string]Transaction) error {
+	for i, vin := range tx.Vin {
+		prevTX, ok := prevTXs[string(vin.TxID)]
+		if !ok || vin.Vout < 0 || vin.Vout >= int64(len(prevTX.Vout)) {
+			return errors.New("PREVIOUS TRANSACTION IS NOT FOUND")
+		}
+		lockOp, lock, _, ok := extractTimelockedP2PKH(prevTX.Vout[vin.Vout].ScriptPubKey)
+		if !ok {
+			continue
+		}
+		if lockOp == OpCheckSequenceVerify {
+			tx.Vin[i].Sequence = uint32(lock)
+			continue
+		}
+		if tx.LockTime != 0 && (tx.LockTime < LockTimeThreshold) != (lock < LockTimeThreshold) {
+			return errors.New("INPUTS HAVE LOCKS OF DIFFERENT TYPES")
+		}
+		if uint64(lock) > tx.LockTime {
+			tx.LockTime = uint64(lock)
+		}
+	}
+	id, err := tx.computeID()
+	if err != nil {
+		return err
+	}
+	tx.ID = id
+	return nil
+}
+
+// returns output paying amount to the address, it is locked with timelocked pay to pubkey hash
+// script if the payment has output lock
+func (locks PaymentLocks) output(amount int64, to string) (*TXOutput, error) {
+	if locks.Until == 0 && locks.Blocks == 0 {
+		return NewTXO(amount, to), nil
+	}
+	if locks.Until != 0 && locks.Blocks != 0 {
+		return nil, errors.New("PAYMENT CAN HAVE ONLY ONE OUTPUT LOCK")
+	}
+	if IsScriptAddress(to) {
+		return nil, errors.New("PAYMENT TO SCRIPT ADDRESS CAN'T BE LOCKED")
+	}
+	if locks.Blocks > SequenceLockMask || locks.Until >= 1<<39 {
+		return nil, errors.New("OUTPUT LOCK IS TOO BIG")
+	}
+	pubKeyHash, err := ExtractPubKeyHash(to)
+	if err != nil {
+		return nil, err
+	}
+	if locks.Until != 0 {
+		return &TXOutput{amount, NewTimelockedP2PKHScript(OpCheckLockTimeVerify, int64(locks.Until), pubKeyHash)}, nil
+	}
+	return &TXOutput{amount, NewTimelockedP2PKHScript(OpCheckSequenceVerify, int64(locks.Blocks), pubKeyHash)}, nil
+}
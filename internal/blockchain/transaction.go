@@ -3,51 +3,73 @@ package blockchain
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/big"
 )
 
-const reward = 50
-
 type Transaction struct {
 	ID   []byte
 	Vin  []TXInput
 	Vout []TXOutput
+	// earliest height or median time the transaction can be included at, see IsFinal
+	LockTime uint64
 }
 
 type TXInput struct {
-	TxID      []byte
-	Vout      int64
-	Signature []byte
-	PubKey    []byte
+	TxID []byte
+	Vout int64
+	// pushes data which satisfies the locking script of the spent output
+	ScriptSig []byte
+	// relative lock of the input, SequenceFinal if there is none
+	Sequence uint32
 }
 
 type TXOutput struct {
-	Value      int64
-	PubKeyHash []byte
+	Value int64
+	// conditions to spend the output, pay to pubkey hash by default
+	ScriptPubKey []byte
 }
 
+// unspent outputs of one transaction keyed by their index
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs map[int64]TXOutput
+	// height of the block which contains the transaction
+	Height   uint64
+	Coinbase bool
 }
 
-func NewCoinbaseTX(to string, data string) (*Transaction, error) {
+// value is subsidy and fees of the block transactions
+func NewCoinbaseTX(to string, data string, height uint64, value int64) (*Transaction, error) {
 	if data == "" {
 		data = fmt.Sprintf("coinbase to '%s'", to)
 	}
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXO(reward, to)
-	tx, err := NewTX([]TXInput{txin}, []TXOutput{*txout})
+	txout := NewTXO(value, to)
+	tx, err := NewTX([]TXInput{coinbaseInput(height, data)}, []TXOutput{*txout})
 	if err != nil {
 		return nil, err
 	}
 	return tx, nil
 }
 
+// height of the block is written before data, so coinbases of different blocks have different ids
+func coinbaseInput(height uint64, data string) TXInput {
+	script := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(script, height)
+	script = append(script, []byte(data)...)
+	return TXInput{[]byte{}, -1, script, SequenceFinal}
+}
+
+// returns height written to coinbase input, false if transaction is not coinbase
+func (tx *Transaction) CoinbaseHeight() (uint64, bool) {
+	if !tx.IsCoinbase() || len(tx.Vin[0].ScriptSig) < 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(tx.Vin[0].ScriptSig[:8]), true
+}
+
 func NewTX(vin []TXInput, vout []TXOutput) (*Transaction, error) {
 	tx := &Transaction{Vin: vin, Vout: vout}
 	hash, err := tx.Hash()
@@ -61,66 +83,91 @@ func NewTX(vin []TXInput, vout []TXOutput) (*Transaction, error) {
 func (tx Transaction) TrimmedCopy() Transaction {
 	var inputs []TXInput
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.TxID, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.TxID, vin.Vout, nil, vin.Sequence})
 	}
 	outputs := append([]TXOutput{}, tx.Vout...)
 	return Transaction{
-		ID:   tx.ID,
-		Vin:  inputs,
-		Vout: outputs,
+		ID:       tx.ID,
+		Vin:      inputs,
+		Vout:     outputs,
+		LockTime: tx.LockTime,
+	}
+}
+
+// returns signature of the input, r and s are padded to 32 bytes and followed by the hash type
+func (tx *Transaction) signInput(index int, scriptCode []byte, privKey ecdsa.PrivateKey, hashType byte) ([]byte, error) {
+	hash, err := tx.signatureHash(index, scriptCode, hashType)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return nil, err
 	}
+	signature := make([]byte, 65)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:64])
+	signature[64] = hashType
+	return signature, nil
 }
 
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+// fills scriptSigs of inputs spending pay to pubkey hash outputs of the wallet, timelocked
+// ones included, with signatures of the hash type. lock time and sequences needed by the
+// spent outputs are set before signing
+func (tx *Transaction) Sign(wallet Wallet, prevTXs map[string]Transaction, hashType byte) error {
 	if tx.IsCoinbase() {
 		return nil
 	}
-	txCopy := tx.TrimmedCopy()
-	for i, vin := range txCopy.Vin {
-		prevTX := prevTXs[string(vin.TxID)]
-		txCopy.Vin[i].Signature = nil
-		txCopy.Vin[i].PubKey = prevTX.Vout[vin.Vout].PubKeyHash
-		id, err := txCopy.Hash()
-		if err != nil {
-			return err
-		}
-		txCopy.ID = id
-		txCopy.Vin[i].PubKey = nil
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
+	err := tx.setLocks(prevTXs)
+	if err != nil {
+		return err
+	}
+	for i := range tx.Vin {
+		err = tx.SignInput(i, wallet, prevTXs, hashType)
 		if err != nil {
 			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-		tx.Vin[i].Signature = signature
 	}
 	return nil
 }
 
+// fills scriptSig of one input spending pay to pubkey hash output of the wallet with signature
+// of the hash type. locks are not changed, so signatures of other inputs stay valid
+func (tx *Transaction) SignInput(index int, wallet Wallet, prevTXs map[string]Transaction, hashType byte) error {
+	if index < 0 || index >= len(tx.Vin) {
+		return errors.New("INVALID INPUT INDEX")
+	}
+	vin := tx.Vin[index]
+	prevTX, ok := prevTXs[string(vin.TxID)]
+	if !ok || vin.Vout < 0 || vin.Vout >= int64(len(prevTX.Vout)) {
+		return errors.New("PREVIOUS TRANSACTION IS NOT FOUND")
+	}
+	scriptPubKey := prevTX.Vout[vin.Vout].ScriptPubKey
+	keyHash, ok := extractP2PKH(scriptPubKey)
+	if !ok {
+		_, _, keyHash, ok = extractTimelockedP2PKH(scriptPubKey)
+	}
+	pubKeyHash := sha256.Sum256(wallet.PublicKey)
+	if !ok || !bytes.Equal(keyHash, pubKeyHash[:]) {
+		return errors.New("INPUT CAN'T BE SIGNED BY THE WALLET")
+	}
+	signature, err := tx.signInput(index, scriptPubKey, wallet.PrivateKey, hashType)
+	if err != nil {
+		return err
+	}
+	tx.Vin[index].ScriptSig = NewP2PKHScriptSig(signature, wallet.PublicKey)
+	return nil
+}
+
+// runs scripts of all inputs
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[string(vin.TxID)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		id, err := txCopy.Hash()
-		if err != nil {
-			return false, err
+	for i, vin := range tx.Vin {
+		prevTX, ok := prevTXs[string(vin.TxID)]
+		if !ok || vin.Vout < 0 || vin.Vout >= int64(len(prevTX.Vout)) {
+			return false, nil
 		}
-		txCopy.ID = id
-		txCopy.Vin[inID].PubKey = nil
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) {
+		err := verifyScript(vin.ScriptSig, prevTX.Vout[vin.Vout].ScriptPubKey, tx, i)
+		if err != nil {
 			return false, nil
 		}
 	}
@@ -128,50 +175,86 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 }
 
 func (tx Transaction) IsCoinbase() bool {
-	if len(tx.Vin) > 1 {
-		return false
-	}
-	return tx.Vin[0].Vout == -1
+	return len(tx.Vin) == 1 && tx.Vin[0].Vout == -1
 }
 
 func (tx *Transaction) Serialize() ([]byte, error) {
-	encoded := new(bytes.Buffer)
-	encoder := gob.NewEncoder(encoded)
-	err := encoder.Encode(tx)
+	return serialize(tx.encode), nil
+}
+
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := &decoder{data: data}
+	d.readVersion()
+	tx := d.transaction()
+	err := d.finish()
 	if err != nil {
 		return nil, err
 	}
-	return encoded.Bytes(), nil
+	return tx, nil
+}
+
+// id is the hash of transaction without scriptSigs, so signatures can't change it.
+// scriptSig of coinbase is kept as it has the height
+func (tx *Transaction) computeID() ([]byte, error) {
+	if tx.IsCoinbase() {
+		return tx.Hash()
+	}
+	txCopy := tx.TrimmedCopy()
+	return txCopy.Hash()
 }
 
+// hash of the canonical encoding, so every node and other tools get the same id
 func (tx Transaction) Hash() ([]byte, error) {
-	idBuf := bytes.Buffer{}
-	encoder := gob.NewEncoder(&idBuf)
-	tx.ID = []byte{}
-	err := encoder.Encode(tx)
-	if err != nil {
-		return nil, err
-	}
-	hash := sha256.Sum256(idBuf.Bytes())
-	return hash[:], err
+	hash := sha256.Sum256(serialize(tx.encode))
+	return hash[:], nil
 }
 
+// checks if scriptSig pushes the key or the redeem script with the hash
 func (in *TXInput) IsUsesKey(keyHash []byte) bool {
-	hash := sha256.Sum256(in.PubKey)
-	return bytes.Equal(keyHash, hash[:])
+	for _, data := range scriptPushes(in.ScriptSig) {
+		hash := sha256.Sum256(data)
+		if bytes.Equal(keyHash, hash[:]) {
+			return true
+		}
+	}
+	return false
 }
 
+// locks output with pay to script hash script if the address has script version
+// and with pay to pubkey hash script otherwise
 func (out *TXOutput) Lock(address string) error {
-	pubKeyHash, err := ExtractPubKeyHash(address)
+	hash, err := ExtractPubKeyHash(address)
 	if err != nil {
 		return err
 	}
-	out.PubKeyHash = pubKeyHash
+	if IsScriptAddress(address) {
+		out.ScriptPubKey = NewP2SHScript(hash)
+	} else {
+		out.ScriptPubKey = NewP2PKHScript(hash)
+	}
 	return nil
 }
 
-func (out *TXOutput) IsLockedWith(keyHash []byte) bool {
-	return bytes.Equal(keyHash, out.PubKeyHash)
+// returns key hash if the output is locked with pay to pubkey hash script
+func (out *TXOutput) KeyHash() ([]byte, bool) {
+	return extractP2PKH(out.ScriptPubKey)
+}
+
+// returns script hash if the output is locked with pay to script hash script
+func (out *TXOutput) ScriptHash() ([]byte, bool) {
+	return extractP2SH(out.ScriptPubKey)
+}
+
+// checks if the output pays to the key, timelocked or not, or to the redeem script with the hash
+func (out *TXOutput) IsLockedWith(hash []byte) bool {
+	keyHash, ok := out.KeyHash()
+	if !ok {
+		_, _, keyHash, ok = extractTimelockedP2PKH(out.ScriptPubKey)
+	}
+	if !ok {
+		keyHash, ok = out.ScriptHash()
+	}
+	return ok && bytes.Equal(hash, keyHash)
 }
 
 func NewTXO(value int64, address string) *TXOutput {
@@ -181,21 +264,22 @@ func NewTXO(value int64, address string) *TXOutput {
 }
 
 func (txos *TXOutputs) Serialize() ([]byte, error) {
-	encoded := new(bytes.Buffer)
-	encoder := gob.NewEncoder(encoded)
-	err := encoder.Encode(txos)
-	if err != nil {
-		return nil, err
-	}
-	return encoded.Bytes(), nil
+	return serialize(txos.encode), nil
+}
+
+// checks if outputs can be spent by a transaction of block with the height,
+// coinbase needs maturity blocks on top of it
+func (txos *TXOutputs) IsMature(spendHeight uint64, maturity uint64) bool {
+	return !txos.Coinbase || spendHeight >= txos.Height+maturity
 }
 
 func DeserializeTXO(data []byte) (*TXOutputs, error) {
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	txos := new(TXOutputs)
-	err := decoder.Decode(txos)
+	d := &decoder{data: data}
+	d.readVersion()
+	txos := d.outputs()
+	err := d.finish()
 	if err != nil {
-		return txos, err
+		return nil, err
 	}
 	return txos, nil
 }
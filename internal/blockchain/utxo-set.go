@@ -2,12 +2,26 @@ package blockchain
 
 import (
 	"bytes"
+	"errors"
 )
 
 type UTXOset struct {
 	bc *Blockchain
 }
 
+type SpentOutput struct {
+	TxID     []byte
+	Vout     int64
+	Output   TXOutput
+	Height   uint64
+	Coinbase bool
+}
+
+// outputs spent by the block in order of non coinbase inputs
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
 func NewUTXOset(bc *Blockchain) *UTXOset {
 	return &UTXOset{bc: bc}
 }
@@ -24,29 +38,31 @@ func (uset *UTXOset) IsActual() (bool, error) {
 	return bytes.Equal(currSetHash, lastBcHash), nil
 }
 
+// rebuilds utxo set by connecting blocks of the main chain from genesis,
+// so every block gets its undo record
 func (uset *UTXOset) Reindex() error {
-	if b, e := uset.IsActual(); e != nil && b {
+	if b, e := uset.IsActual(); e == nil && b {
 		return nil
 	}
 	err := uset.bc.db.ClearUTXOset()
 	if err != nil {
 		return err
 	}
-	lastHash, err := uset.bc.db.GetLast()
+	err = uset.bc.db.UpdateUTXOBlock([]byte{})
 	if err != nil {
 		return err
 	}
-	err = uset.bc.db.UpdateUTXOBlock(lastHash)
-	if err != nil {
-		return err
+	hashes := [][]byte{}
+	bcIter := uset.bc.Iterator()
+	for bcIter.Next() {
+		hashes = append(hashes, bcIter.Block().Hash)
 	}
-	utxo := uset.bc.FindUTXO()
-	for id, txos := range utxo {
-		serialized, err := txos.Serialize()
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := uset.bc.GetBlock(hashes[i])
 		if err != nil {
 			return err
 		}
-		err = uset.bc.db.AddTXO([]byte(id), serialized)
+		err = uset.connectBlock(block)
 		if err != nil {
 			return err
 		}
@@ -61,72 +77,251 @@ func (uset *UTXOset) UpdateWithBlock(lastBlock *Block) error {
 		return err
 	}
 	if !bytes.Equal(prevSync, lastBlock.PrevHash) {
-		err = uset.Reindex()
+		return uset.Reindex()
+	}
+	return uset.connectBlock(lastBlock)
+}
+
+// applies the block to utxo set, outputs spent by the block are saved as undo record
+func (uset *UTXOset) connectBlock(block *Block) error {
+	undo := BlockUndo{Spent: []SpentOutput{}}
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, txi := range tx.Vin {
+				outs, err := uset.getOutputs(txi.TxID)
+				if err != nil {
+					return err
+				}
+				out, ok := outs.Outputs[txi.Vout]
+				if !ok {
+					return errors.New("SPENT OUTPUT IS NOT IN UTXO SET")
+				}
+				undo.Spent = append(undo.Spent, SpentOutput{
+					TxID:     txi.TxID,
+					Vout:     txi.Vout,
+					Output:   out,
+					Height:   outs.Height,
+					Coinbase: outs.Coinbase,
+				})
+				delete(outs.Outputs, txi.Vout)
+				err = uset.putOutputs(txi.TxID, outs)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		newOuts := TXOutputs{Outputs: map[int64]TXOutput{}, Height: block.Height, Coinbase: tx.IsCoinbase()}
+		for i, out := range tx.Vout {
+			newOuts.Outputs[int64(i)] = out
+		}
+		err := uset.putOutputs(tx.ID, &newOuts)
 		if err != nil {
 			return err
 		}
-		return nil
 	}
-	err = uset.bc.db.UpdateUTXOBlock(lastBlock.Hash)
+	serialized, err := undo.Serialize()
 	if err != nil {
 		return err
 	}
-	for _, tx := range lastBlock.Transactions {
-		newOuts := TXOutputs{}
-		newOuts.Outputs = append(newOuts.Outputs, tx.Vout...)
-		serialized, err := newOuts.Serialize()
-		if err != nil {
-			return err
-		}
-		err = uset.bc.db.AddTXO(tx.ID, serialized)
+	err = uset.bc.db.AddUndo(block.Hash, serialized)
+	if err != nil {
+		return err
+	}
+	return uset.bc.db.UpdateUTXOBlock(block.Hash)
+}
+
+// reverts changes made by the last synced block using its undo record
+func (uset *UTXOset) DisconnectBlock(block *Block) error {
+	synced, err := uset.bc.db.GetUTXOBlock()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(synced, block.Hash) {
+		return errors.New("BLOCK IS NOT THE LAST SYNCED BLOCK")
+	}
+	serialized, err := uset.bc.db.GetUndo(block.Hash)
+	if err != nil {
+		return err
+	}
+	if len(serialized) == 0 {
+		return errors.New("UNDO RECORD IS NOT FOUND")
+	}
+	undo, err := DeserializeUndo(serialized)
+	if err != nil {
+		return err
+	}
+	i := len(undo.Spent) - 1
+	for t := len(block.Transactions) - 1; t >= 0; t-- {
+		tx := block.Transactions[t]
+		err = uset.bc.db.DeleteUTXO(tx.ID)
 		if err != nil {
 			return err
 		}
 		if tx.IsCoinbase() {
 			continue
 		}
-		serialized, err = uset.bc.db.GetUTXO(tx.ID)
-		if err != nil {
-			return err
-		}
-		outs, err := DeserializeTXO(serialized)
-		if err != nil {
-			return err
-		}
-		updatedOuts := TXOutputs{}
-		for _, txi := range tx.Vin {
-			for _, out := range outs.Outputs {
-				if !bytes.Equal(out.PubKeyHash, txi.PubKey) {
-					updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-				}
+		for range tx.Vin {
+			if i < 0 {
+				return errors.New("UNDO RECORD DOES NOT MATCH BLOCK")
 			}
-		}
-		if len(updatedOuts.Outputs) != 0 {
-			serialized, err = updatedOuts.Serialize()
+			spent := undo.Spent[i]
+			i--
+			outs, err := uset.getOutputs(spent.TxID)
 			if err != nil {
 				return err
 			}
-			err = uset.bc.db.AddTXO(tx.ID, serialized)
-			if err != nil {
-				return err
-			}
-		} else {
-			err = uset.bc.db.DeleteUTXO(tx.ID)
+			outs.Outputs[spent.Vout] = spent.Output
+			outs.Height = spent.Height
+			outs.Coinbase = spent.Coinbase
+			err = uset.putOutputs(spent.TxID, outs)
 			if err != nil {
 				return err
 			}
 		}
 	}
+	return uset.bc.db.UpdateUTXOBlock(block.PrevHash)
+}
+
+func (undo *BlockUndo) Serialize() ([]byte, error) {
+	return serialize(undo.encode), nil
+}
+
+func DeserializeUndo(data []byte) (*BlockUndo, error) {
+	d := &decoder{data: data}
+	d.readVersion()
+	undo := d.undo()
+	err := d.finish()
+	if err != nil {
+		return nil, err
+	}
+	return undo, nil
+}
+
+// returns unspent outputs of the transaction, empty if it has no such outputs
+func (uset *UTXOset) getOutputs(txID []byte) (*TXOutputs, error) {
+	serialized, err := uset.bc.db.GetUTXO(txID)
+	if err != nil {
+		return nil, err
+	}
+	if len(serialized) == 0 {
+		return &TXOutputs{Outputs: map[int64]TXOutput{}}, nil
+	}
+	return DeserializeTXO(serialized)
+}
+
+// stores unspent outputs of the transaction or deletes the record if there are none
+func (uset *UTXOset) putOutputs(txID []byte, outs *TXOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return uset.bc.db.DeleteUTXO(txID)
+	}
+	serialized, err := outs.Serialize()
+	if err != nil {
+		return err
+	}
+	return uset.bc.db.AddTXO(txID, serialized)
+}
+
+// returns output if it exists and is not spent, nil otherwise
+func (uset *UTXOset) FindOutput(txID []byte, vout int64) (*TXOutput, error) {
+	outs, err := uset.getOutputs(txID)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := outs.Outputs[vout]
+	if !ok {
+		return nil, nil
+	}
+	return &out, nil
+}
+
+// returns ErrImmatureCoinbase if outputs of the transaction can't be spent in block with the height yet
+func (uset *UTXOset) checkMaturity(txID []byte, spendHeight uint64) error {
+	outs, err := uset.getOutputs(txID)
+	if err != nil {
+		return err
+	}
+	if !outs.IsMature(spendHeight, uset.bc.params.CoinbaseMaturity) {
+		return ErrImmatureCoinbase
+	}
 	return nil
 }
 
+// returns sums of outputs locked with the key which can be spent in the next block
+// and of coinbase outputs which are not mature yet and timelocked outputs
+func (uset *UTXOset) Balance(pubKeyHash []byte) (int64, int64, error) {
+	height, err := uset.bc.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+	medianTime, err := uset.bc.tipMedianTime()
+	if err != nil {
+		return 0, 0, err
+	}
+	var balance, immature int64
+	si, err := uset.bc.db.UTXOiterator()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer si.Close()
+	for si.Next() {
+		outs, err := DeserializeTXO(si.Get().Txo)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, out := range outs.Outputs {
+			if !out.IsLockedWith(pubKeyHash) {
+				continue
+			}
+			if outs.IsMature(height+1, uset.bc.params.CoinbaseMaturity) && out.IsUnlocked(outs.Height, height+1, medianTime) {
+				balance += out.Value
+			} else {
+				immature += out.Value
+			}
+		}
+	}
+	return balance, immature, nil
+}
+
+// returns sum of all unspent outputs, ErrBadValue if it is out of money range
+func (uset *UTXOset) TotalValue() (int64, error) {
+	var total int64
+	si, err := uset.bc.db.UTXOiterator()
+	if err != nil {
+		return 0, err
+	}
+	defer si.Close()
+	for si.Next() {
+		outs, err := DeserializeTXO(si.Get().Txo)
+		if err != nil {
+			return 0, err
+		}
+		for _, out := range outs.Outputs {
+			var ok bool
+			total, ok = addMoney(total, out.Value)
+			if !ok {
+				return 0, ErrBadValue
+			}
+		}
+	}
+	return total, nil
+}
+
 func (uset *UTXOset) FindSpendableOuts(pubKeyHash []byte, amount int64) (int64, map[string][]int64, error) {
 	spendableOuts := map[string][]int64{}
 	var balance int64 = 0
+	height, err := uset.bc.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+	medianTime, err := uset.bc.tipMedianTime()
+	if err != nil {
+		return 0, nil, err
+	}
 	si, err := uset.bc.db.UTXOiterator()
 	if err != nil {
 		return 0, nil, err
 	}
+	defer si.Close()
 	for si.Next() {
 		elem := si.Get()
 		txId := string(elem.TxHash)
@@ -134,13 +329,20 @@ func (uset *UTXOset) FindSpendableOuts(pubKeyHash []byte, amount int64) (int64,
 		if err != nil {
 			return 0, nil, err
 		}
+		if !outs.IsMature(height+1, uset.bc.params.CoinbaseMaturity) {
+			continue
+		}
 		for i, out := range outs.Outputs {
 			if balance >= amount {
 				return balance, spendableOuts, nil
 			}
-			if out.IsLockedWith(pubKeyHash) {
+			// outputs spent by mempool transactions would make a double spend
+			if uset.bc.mempool.isSpent(elem.TxHash, i) {
+				continue
+			}
+			if out.IsLockedWith(pubKeyHash) && out.IsUnlocked(outs.Height, height+1, medianTime) {
 				balance += out.Value
-				spendableOuts[txId] = append(spendableOuts[txId], int64(i))
+				spendableOuts[txId] = append(spendableOuts[txId], i)
 			}
 		}
 	}
@@ -153,6 +355,7 @@ func (uset *UTXOset) FindUnspentTXO(pubKeyHash []byte) ([]TXOutput, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer si.Close()
 	for si.Next() {
 		elem := si.Get()
 		outs, err := DeserializeTXO(elem.Txo)
@@ -0,0 +1,278 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// block time can be this many seconds ahead of local time
+const maxFutureBlockTime = 2 * 60 * 60
+
+var (
+	ErrBadBlockHash         = errors.New("BLOCK HASH DOES NOT MATCH ITS DATA")
+	ErrBadProofOfWork       = errors.New("BLOCK HASH DOES NOT SATISFY TARGET")
+	ErrBadTarget            = errors.New("INVALID BLOCK TARGET")
+	ErrTimeTooOld           = errors.New("BLOCK TIME IS NOT AFTER MEDIAN TIME PAST")
+	ErrTimeTooNew           = errors.New("BLOCK TIME IS TOO FAR IN THE FUTURE")
+	ErrBadMerkleRoot        = errors.New("MERKLE ROOT DOES NOT MATCH TRANSACTIONS")
+	ErrBadTransactionID     = errors.New("TRANSACTION ID DOES NOT MATCH ITS DATA")
+	ErrBadGenesis           = errors.New("BLOCK IS NOT GENESIS OF THE NETWORK")
+	ErrBadHeight            = errors.New("INVALID BLOCK HEIGHT")
+	ErrNoTransactions       = errors.New("BLOCK HAS NO TRANSACTIONS")
+	ErrNoCoinbase           = errors.New("FIRST TRANSACTION IS NOT COINBASE")
+	ErrMultipleCoinbase     = errors.New("BLOCK HAS MORE THAN ONE COINBASE")
+	ErrBadCoinbaseHeight    = errors.New("COINBASE HEIGHT DOES NOT MATCH BLOCK")
+	ErrBadCoinbaseValue     = errors.New("COINBASE PAYS MORE THAN SUBSIDY AND FEES")
+	ErrDuplicateTransaction = errors.New("DUPLICATE TRANSACTION IN BLOCK")
+	ErrMissingOutput        = errors.New("TRANSACTION SPENDS UNKNOWN OR SPENT OUTPUT")
+	ErrDoubleSpend          = errors.New("OUTPUT IS SPENT TWICE IN BLOCK")
+	ErrImmatureCoinbase     = errors.New("COINBASE OUTPUT IS NOT MATURE")
+	ErrBadTransaction       = errors.New("INVALID TRANSACTION")
+	ErrBadValue             = errors.New("VALUE IS OUT OF MONEY RANGE")
+)
+
+// checks block against consensus rules. ErrOrphanBlock is returned if parent is unknown.
+// genesis block is checked only by its hash, so its coinbase can create any allocations.
+// spent outputs can be checked only against utxo set of the current tip, so they are
+// checked for blocks extending it, blocks of side branches are checked when they are connected
+func ValidateBlock(bc *Blockchain, block *Block) error {
+	err := checkBlock(bc, block)
+	if err != nil {
+		return err
+	}
+	if len(block.PrevHash) == 0 || !bytes.Equal(block.PrevHash, bc.tip) {
+		return nil
+	}
+	return checkBlockTransactions(bc, block.Transactions, block.Height)
+}
+
+// checks header, its link to the parent and transactions which do not depend on utxo set
+func checkBlock(bc *Blockchain, block *Block) error {
+	hash := block.computeHash()
+	if !bytes.Equal(hash, block.Hash) {
+		return ErrBadBlockHash
+	}
+	err := checkTarget(bc.params, block.Nbits)
+	if err != nil {
+		return err
+	}
+	var hashNum big.Int
+	hashNum.SetBytes(hash)
+	if hashNum.Cmp(getTarget(block.Nbits)) != -1 {
+		return ErrBadProofOfWork
+	}
+	err = checkTransactionIDs(block.Transactions)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+	if len(block.PrevHash) == 0 {
+		if !bytes.Equal(block.Hash, bc.genesis) {
+			return ErrBadGenesis
+		}
+		return nil
+	}
+	if !bc.HasBlock(block.PrevHash) {
+		err = bc.checkOrphanTarget(block.Nbits)
+		if err != nil {
+			return err
+		}
+		return ErrOrphanBlock
+	}
+	parent, err := bc.GetBlock(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	if block.Height != parent.Height+1 {
+		return ErrBadHeight
+	}
+	parentHeader := parent.Header()
+	nbits, err := bc.expectedBits(&parentHeader, nil)
+	if err != nil {
+		return err
+	}
+	if block.Nbits != nbits {
+		return ErrBadTarget
+	}
+	return checkTimestamp(&block.BlockHeader, &parentHeader, func(hash []byte) (*BlockHeader, error) {
+		return bc.getHeader(hash, nil)
+	})
+}
+
+// block time must be after median time past of the parent, so median time never goes back
+// and retargeting can't be skewed by old timestamps, and not too far ahead of local time
+func checkTimestamp(header *BlockHeader, parent *BlockHeader, getHeader func([]byte) (*BlockHeader, error)) error {
+	medianTime, err := medianTimePast(parent, getHeader)
+	if err != nil {
+		return err
+	}
+	if header.Timestamp <= medianTime {
+		return ErrTimeTooOld
+	}
+	if header.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+	return nil
+}
+
+// transactions are spent by their ids, so every id must be computed from its transaction
+func checkTransactionIDs(transactions []*Transaction) error {
+	if len(transactions) == 0 {
+		return ErrNoTransactions
+	}
+	ids := map[string]bool{}
+	for _, tx := range transactions {
+		id, err := tx.computeID()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(id, tx.ID) {
+			return ErrBadTransactionID
+		}
+		if ids[string(tx.ID)] {
+			return ErrDuplicateTransaction
+		}
+		ids[string(tx.ID)] = true
+	}
+	return nil
+}
+
+// checks transactions of a block with the height which extends the current tip.
+// coinbase can claim subsidy and fees of other transactions
+func checkBlockTransactions(bc *Blockchain, transactions []*Transaction, height uint64) error {
+	if len(transactions) == 0 {
+		return ErrNoTransactions
+	}
+	coinbase := transactions[0]
+	if !coinbase.IsCoinbase() {
+		return ErrNoCoinbase
+	}
+	if h, ok := coinbase.CoinbaseHeight(); !ok || h != height {
+		return ErrBadCoinbaseHeight
+	}
+	fees, err := checkTransactions(bc, transactions[1:], height)
+	if err != nil {
+		return err
+	}
+	var coinbaseValue int64
+	for _, out := range coinbase.Vout {
+		var ok bool
+		coinbaseValue, ok = addMoney(coinbaseValue, out.Value)
+		if !ok {
+			return ErrBadValue
+		}
+	}
+	if coinbaseValue > bc.params.Subsidy(height)+fees {
+		return ErrBadCoinbaseValue
+	}
+	return nil
+}
+
+// checks non coinbase transactions of a block with the height extending the current tip and
+// returns sum of their fees. inputs must spend mature outputs of utxo set or outputs of
+// earlier transactions of the block, time locks of transactions must be reached.
+// values and their sums must be in money range
+func checkTransactions(bc *Blockchain, transactions []*Transaction, height uint64) (int64, error) {
+	c, err := newTxChecker(bc, height)
+	if err != nil {
+		return 0, err
+	}
+	var fees int64
+	for _, tx := range transactions {
+		fee, err := c.check(tx)
+		if err != nil {
+			return 0, err
+		}
+		var ok bool
+		fees, ok = addMoney(fees, fee)
+		if !ok {
+			return 0, ErrBadValue
+		}
+	}
+	return fees, nil
+}
+
+// checks transactions of a block one by one, outputs of accepted ones can be spent by later ones
+type txChecker struct {
+	bc      *Blockchain
+	height  uint64
+	parent  *BlockHeader
+	pending map[string]*Transaction
+	spent   map[string]bool
+}
+
+func newTxChecker(bc *Blockchain, height uint64) (*txChecker, error) {
+	parent, err := bc.getHeader(bc.tip, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txChecker{bc, height, parent, map[string]*Transaction{}, map[string]bool{}}, nil
+}
+
+// checks transaction and returns its fee. rejected transaction doesn't change the state,
+// so checking can go on with the next one
+func (c *txChecker) check(tx *Transaction) (int64, error) {
+	if tx.IsCoinbase() {
+		return 0, ErrMultipleCoinbase
+	}
+	var inSum, outSum int64
+	var ok bool
+	keys := map[string]bool{}
+	for _, in := range tx.Vin {
+		key := outpointKey(in.TxID, in.Vout)
+		if c.spent[key] || keys[key] {
+			return 0, ErrDoubleSpend
+		}
+		keys[key] = true
+		if prev, found := c.pending[string(in.TxID)]; found {
+			if in.Vout < 0 || in.Vout >= int64(len(prev.Vout)) {
+				return 0, ErrMissingOutput
+			}
+			inSum, ok = addMoney(inSum, prev.Vout[in.Vout].Value)
+			if !ok {
+				return 0, ErrBadValue
+			}
+			continue
+		}
+		out, err := c.bc.utxoset.FindOutput(in.TxID, in.Vout)
+		if err != nil {
+			return 0, err
+		}
+		if out == nil {
+			return 0, ErrMissingOutput
+		}
+		err = c.bc.utxoset.checkMaturity(in.TxID, c.height)
+		if err != nil {
+			return 0, err
+		}
+		inSum, ok = addMoney(inSum, out.Value)
+		if !ok {
+			return 0, ErrBadValue
+		}
+	}
+	err := c.bc.checkLocks(tx, c.pending, c.height, c.parent)
+	if err != nil {
+		return 0, err
+	}
+	if ok, err := c.bc.verifyTransactionWith(tx, c.pending); err != nil || !ok {
+		return 0, ErrBadTransaction
+	}
+	for _, out := range tx.Vout {
+		outSum, ok = addMoney(outSum, out.Value)
+		if !ok {
+			return 0, ErrBadValue
+		}
+	}
+	if outSum > inSum {
+		return 0, ErrBadTransaction
+	}
+	for key := range keys {
+		c.spent[key] = true
+	}
+	c.pending[string(tx.ID)] = tx
+	return inSum - outSum, nil
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/x509"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"os"
 
 	"github.com/akamensky/base58"
@@ -16,6 +17,8 @@ import (
 
 const checksumLen = 4
 
+var ErrTooManyMultisigKeys = errors.New("MULTISIG ADDRESS CAN HAVE AT MOST 7 KEYS")
+
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
@@ -28,10 +31,13 @@ type SerializedWallet struct {
 
 type Wallets struct {
 	Wallets map[string]Wallet
+	// redeem scripts of pay to script hash addresses
+	Scripts map[string][]byte
 }
 
 type SerializedWallets struct {
 	Wallets map[string]SerializedWallet
+	Scripts map[string][]byte
 }
 
 func NewWallet() (*Wallet, error) {
@@ -47,7 +53,10 @@ func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	if err != nil {
 		return *private, nil, err
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// coordinates are padded, so the key can be split in halves
+	pubKey := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pubKey[:32])
+	private.PublicKey.Y.FillBytes(pubKey[32:])
 	return *private, pubKey, nil
 }
 
@@ -77,6 +86,17 @@ func ValidateAddress(address string) (bool, error) {
 	return bytes.Equal(actualChecksum, targetChecksum), nil
 }
 
+func ExtractVersion(address string) (uint32, error) {
+	data, err := base58.Decode(address)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 4+checksumLen {
+		return 0, errors.New("INVALID ADDRESS")
+	}
+	return binary.LittleEndian.Uint32(data[:4]), nil
+}
+
 func (ws Wallets) GetWallet(address string) Wallet {
 	return ws.Wallets[address]
 }
@@ -95,12 +115,12 @@ func checksum(data []byte) []byte {
 	return second[:checksumLen]
 }
 
-func (ws *Wallets) CreateWallet() (string, error) {
+func (ws *Wallets) CreateWallet(version uint32) (string, error) {
 	wallet, err := NewWallet()
 	if err != nil {
 		return "", nil
 	}
-	address, err := wallet.Address(BlockchainVersion)
+	address, err := wallet.Address(version)
 	if err != nil {
 		return "", err
 	}
@@ -109,6 +129,27 @@ func (ws *Wallets) CreateWallet() (string, error) {
 	return address, nil
 }
 
+// creates address of script which needs required signatures of the keys
+func (ws *Wallets) CreateMultisig(required int, pubKeys [][]byte, version uint32) (string, error) {
+	if len(pubKeys) > MaxMultisigAddressKeys {
+		return "", ErrTooManyMultisigKeys
+	}
+	script, err := NewMultisigScript(required, pubKeys)
+	if err != nil {
+		return "", err
+	}
+	if len(script) > maxScriptElementSize {
+		return "", errors.New("MULTISIG SCRIPT IS TOO LARGE")
+	}
+	scriptHash := sha256.Sum256(script)
+	address, err := GetAddress(scriptHash[:], version)
+	if err != nil {
+		return "", err
+	}
+	ws.Scripts[address] = script
+	return address, nil
+}
+
 func NewWallets(filePath string) (*Wallets, error) {
 	ws := new(Wallets)
 	err := ws.LoadFromFile(filePath)
@@ -116,6 +157,9 @@ func NewWallets(filePath string) (*Wallets, error) {
 		ws.Wallets = map[string]Wallet{}
 		err = nil
 	}
+	if ws.Scripts == nil {
+		ws.Scripts = map[string][]byte{}
+	}
 	return ws, err
 }
 
@@ -133,6 +177,7 @@ func (w Wallet) Serialize() (*SerializedWallet, error) {
 func (ws *Wallets) Serialize() (*SerializedWallets, error) {
 	sws := new(SerializedWallets)
 	sws.Wallets = make(map[string]SerializedWallet, len(ws.Wallets))
+	sws.Scripts = ws.Scripts
 	for a, w := range ws.Wallets {
 		sw, err := w.Serialize()
 		if err != nil {
@@ -157,6 +202,7 @@ func (sw SerializedWallet) Deserialize() (*Wallet, error) {
 func (sws *SerializedWallets) Deserialize() (*Wallets, error) {
 	ws := new(Wallets)
 	ws.Wallets = make(map[string]Wallet, len(sws.Wallets))
+	ws.Scripts = sws.Scripts
 	for a, sw := range sws.Wallets {
 		w, err := sw.Deserialize()
 		if err != nil {
@@ -209,5 +255,6 @@ func (ws *Wallets) LoadFromFile(filepath string) error {
 		return err
 	}
 	ws.Wallets = decodedWallets.Wallets
+	ws.Scripts = decodedWallets.Scripts
 	return nil
 }
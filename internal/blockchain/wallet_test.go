@@ -0,0 +1,26 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCreateMultisigKeyLimit(t *testing.T) {
+	pubKeys := [][]byte{}
+	for i := 0; i < MaxMultisigAddressKeys+1; i++ {
+		w, err := NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	ws := &Wallets{Wallets: map[string]Wallet{}, Scripts: map[string][]byte{}}
+	_, err := ws.CreateMultisig(MaxMultisigAddressKeys, pubKeys[:MaxMultisigAddressKeys], RegtestParams.ScriptAddressVersion)
+	if err != nil {
+		t.Fatalf("%d keys: %s", MaxMultisigAddressKeys, err)
+	}
+	_, err = ws.CreateMultisig(1, pubKeys, RegtestParams.ScriptAddressVersion)
+	if !errors.Is(err, ErrTooManyMultisigKeys) {
+		t.Fatalf("%d keys: got %v, want %v", len(pubKeys), err, ErrTooManyMultisigKeys)
+	}
+}
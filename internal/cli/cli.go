@@ -3,8 +3,11 @@ package cli
 import (
 	"bchain/internal/blockchain"
 	database "bchain/internal/db"
+	"bchain/internal/network"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 )
 
 const (
@@ -14,6 +17,19 @@ const (
 	createWalletFlagName = "createwallet"
 	printWalletsFlagName = "printwallets"
 	listenFlagName       = "listen"
+	supplyFlagName       = "supply"
+	mineFlagName         = "mine"
+	syncFlagName         = "sync"
+	createMultisigName   = "createmultisig"
+	multisigTXName       = "multisigtx"
+	signMultisigName     = "signmultisig"
+	sendMultisigName     = "sendmultisig"
+	initiateSwapName     = "initiateswap"
+	participateSwapName  = "participateswap"
+	redeemSwapName       = "redeemswap"
+	refundSwapName       = "refundswap"
+	auditSwapName        = "auditswap"
+	mineGenesisName      = "minegenesis"
 	helpFlagName         = "help"
 )
 
@@ -21,16 +37,18 @@ type CLI struct {
 	db      *database.DB
 	bc      *blockchain.Blockchain
 	utxoSet *blockchain.UTXOset
+	params  *blockchain.ChainParams
+	// light client keeps only headers and proven wallet transactions
+	light bool
+	hc    *blockchain.HeaderChain
 }
 
-func NewCli(db *database.DB) *CLI {
-	return &CLI{
-		db: db,
-	}
+func NewCli() *CLI {
+	return &CLI{}
 }
 
-func (cli *CLI) createBlockChain(address string) error {
-	bc, err := blockchain.NewBlockchain(cli.db, address)
+func (cli *CLI) createBlockChain() error {
+	bc, err := blockchain.NewBlockchain(cli.db, cli.params)
 	if err != nil {
 		return err
 	}
@@ -44,76 +62,324 @@ func (cli *CLI) createBlockChain(address string) error {
 	return nil
 }
 
-func (cli *CLI) isValidFlags() bool {
-	return len(os.Args) >= 2
+func (cli *CLI) createHeaderChain() error {
+	hc, err := blockchain.NewHeaderChain(cli.db, cli.params)
+	if err != nil {
+		return err
+	}
+	cli.hc = hc
+	return nil
+}
+
+func (cli *CLI) isValidFlags(args []string) bool {
+	return len(args) >= 1
+}
+
+// commands which work without blocks
+func isLightCommand(command string) bool {
+	switch command {
+	case sendFlagName, getBalanceFlagName, createWalletFlagName, printWalletsFlagName, syncFlagName, helpFlagName,
+		createMultisigName, signMultisigName, mineGenesisName:
+		return true
+	}
+	return false
+}
+
+// parses value of -sighash flag, unknown hash type stops the command
+func parseSigHash(name string) byte {
+	hashType, err := blockchain.ParseSigHashType(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return hashType
 }
 
+// network and light mode are selected by flags placed before the command
 func (cli *CLI) Run() {
-	if !cli.isValidFlags() {
+	globalFlag := flag.NewFlagSet("bchain", flag.ExitOnError)
+	netName := globalFlag.String("net", blockchain.MainnetParams.Name, "network: mainnet, testnet or regtest")
+	genesisFile := globalFlag.String("genesis", "", "genesis spec file replacing the one of the network")
+	light := globalFlag.Bool("light", false, "light client mode, only headers and proofs of wallet transactions are stored")
+	globalFlag.Parse(os.Args[1:])
+	args := globalFlag.Args()
+	if !cli.isValidFlags(args) {
 		cli.printHelp()
 		os.Exit(1)
 	}
+	params, err := blockchain.GetChainParams(*netName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *genesisFile != "" {
+		spec, err := blockchain.LoadGenesisSpec(*genesisFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		custom := *params
+		custom.Genesis = spec
+		params = &custom
+	}
+	cli.params = params
+	cli.light = *light
+	dbFile := params.DBFile
+	if cli.light {
+		if !isLightCommand(args[0]) {
+			fmt.Println("Command is not available in light mode")
+			os.Exit(1)
+		}
+		dbFile = params.LightDBFile
+	}
+	cli.db, err = database.NewDb(dbFile)
+	if err != nil {
+		panic(err)
+	}
 
 	sendFlag := flag.NewFlagSet(sendFlagName, flag.ExitOnError)
 	sendFrom := sendFlag.String("f", "", "from addres")
 	sendTo := sendFlag.String("t", "", " to address")
 	sendAmount := sendFlag.Int64("a", 0, "amount")
+	sendFee := sendFlag.Int64("fee", 0, "fee paid to the miner")
+	sendLockTime := sendFlag.Uint64("locktime", 0, "height or unix time the transaction can't be mined before")
+	sendUntil := sendFlag.Uint64("until", 0, "height or unix time the recipient can't spend the payment before")
+	sendAfter := sendFlag.Uint("after", 0, "number of blocks after the transaction the recipient can't spend the payment")
+	sendMine := sendFlag.Bool("m", false, "mine the transaction immediately on this node")
+	sendNode := sendFlag.String("n", "", "node to pass the transaction to")
+	sendSigHash := sendFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
 
 	printChainFlag := flag.NewFlagSet(printChainFlagName, flag.ExitOnError)
 
+	mineGenesisFlag := flag.NewFlagSet(mineGenesisName, flag.ExitOnError)
+
 	getBalanceFlag := flag.NewFlagSet(getBalanceFlagName, flag.ExitOnError)
 	getBalanceAddr := getBalanceFlag.String("a", "", "address")
 
 	createWalletFlag := flag.NewFlagSet(createWalletFlagName, flag.ExitOnError)
 
 	printWalletsFlag := flag.NewFlagSet(printWalletsFlagName, flag.ExitOnError)
+	printWalletsKeys := printWalletsFlag.Bool("k", false, "print public keys of wallets")
+
+	createMultisigFlag := flag.NewFlagSet(createMultisigName, flag.ExitOnError)
+	createMultisigRequired := createMultisigFlag.Int("m", 0, "number of required signatures")
+	createMultisigKeys := createMultisigFlag.String("k", "", "comma separated public keys in hex")
+
+	multisigTXFlag := flag.NewFlagSet(multisigTXName, flag.ExitOnError)
+	multisigTXFrom := multisigTXFlag.String("f", "", "multisig address")
+	multisigTXTo := multisigTXFlag.String("t", "", "to address")
+	multisigTXAmount := multisigTXFlag.Int64("a", 0, "amount")
+	multisigTXFee := multisigTXFlag.Int64("fee", 0, "fee paid to the miner")
+	multisigTXFile := multisigTXFlag.String("o", "", "file the unsigned transaction is written to")
+
+	signMultisigFlag := flag.NewFlagSet(signMultisigName, flag.ExitOnError)
+	signMultisigFile := signMultisigFlag.String("i", "", "multisig transaction file")
+	signMultisigAddr := signMultisigFlag.String("a", "", "address of the signing wallet")
+	signMultisigSigHash := signMultisigFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
+
+	sendMultisigFlag := flag.NewFlagSet(sendMultisigName, flag.ExitOnError)
+	sendMultisigFile := sendMultisigFlag.String("i", "", "multisig transaction file")
+	sendMultisigNode := sendMultisigFlag.String("n", "", "node to pass the transaction to")
+
+	initiateSwapFlag := flag.NewFlagSet(initiateSwapName, flag.ExitOnError)
+	initiateSwapFrom := initiateSwapFlag.String("f", "", "address paying to the contract and receiving refund")
+	initiateSwapTo := initiateSwapFlag.String("t", "", "address of the participant on this chain")
+	initiateSwapAmount := initiateSwapFlag.Int64("a", 0, "amount")
+	initiateSwapFee := initiateSwapFlag.Int64("fee", 0, "fee paid to the miner")
+	initiateSwapDuration := initiateSwapFlag.Uint64("d", 48*60*60, "number of seconds from now the contract can be refunded after")
+	initiateSwapMine := initiateSwapFlag.Bool("m", false, "mine the transaction immediately on this node")
+	initiateSwapNode := initiateSwapFlag.String("n", "", "node to pass the transaction to")
+	initiateSwapSigHash := initiateSwapFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
+
+	participateSwapFlag := flag.NewFlagSet(participateSwapName, flag.ExitOnError)
+	participateSwapFrom := participateSwapFlag.String("f", "", "address paying to the contract and receiving refund")
+	participateSwapTo := participateSwapFlag.String("t", "", "address of the initiator on this chain")
+	participateSwapAmount := participateSwapFlag.Int64("a", 0, "amount")
+	participateSwapFee := participateSwapFlag.Int64("fee", 0, "fee paid to the miner")
+	participateSwapContract := participateSwapFlag.String("c", "", "initiator contract in hex")
+	participateSwapDuration := participateSwapFlag.Uint64("d", 24*60*60, "number of seconds from now the contract can be refunded after")
+	participateSwapMine := participateSwapFlag.Bool("m", false, "mine the transaction immediately on this node")
+	participateSwapNode := participateSwapFlag.String("n", "", "node to pass the transaction to")
+	participateSwapSigHash := participateSwapFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
+
+	redeemSwapFlag := flag.NewFlagSet(redeemSwapName, flag.ExitOnError)
+	redeemSwapContract := redeemSwapFlag.String("c", "", "contract in hex")
+	redeemSwapTX := redeemSwapFlag.String("tx", "", "id of the transaction paying to the contract")
+	redeemSwapSecret := redeemSwapFlag.String("s", "", "secret in hex")
+	redeemSwapFee := redeemSwapFlag.Int64("fee", 0, "fee paid to the miner")
+	redeemSwapMine := redeemSwapFlag.Bool("m", false, "mine the transaction immediately on this node")
+	redeemSwapNode := redeemSwapFlag.String("n", "", "node to pass the transaction to")
+	redeemSwapSigHash := redeemSwapFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
+
+	refundSwapFlag := flag.NewFlagSet(refundSwapName, flag.ExitOnError)
+	refundSwapContract := refundSwapFlag.String("c", "", "contract in hex")
+	refundSwapTX := refundSwapFlag.String("tx", "", "id of the transaction paying to the contract")
+	refundSwapFee := refundSwapFlag.Int64("fee", 0, "fee paid to the miner")
+	refundSwapMine := refundSwapFlag.Bool("m", false, "mine the transaction immediately on this node")
+	refundSwapNode := refundSwapFlag.String("n", "", "node to pass the transaction to")
+	refundSwapSigHash := refundSwapFlag.String("sighash", "all", "signature hash type: all, none or single, optionally with |anyonecanpay")
+
+	auditSwapFlag := flag.NewFlagSet(auditSwapName, flag.ExitOnError)
+	auditSwapContract := auditSwapFlag.String("c", "", "contract in hex")
+	auditSwapTX := auditSwapFlag.String("tx", "", "id of the transaction paying to the contract")
+
+	supplyFlag := flag.NewFlagSet(supplyFlagName, flag.ExitOnError)
+
+	mineFlag := flag.NewFlagSet(mineFlagName, flag.ExitOnError)
+	mineAddr := mineFlag.String("a", "", "address which receives rewards")
+	mineCount := mineFlag.Int("c", 1, "number of blocks")
+
+	syncFlag := flag.NewFlagSet(syncFlagName, flag.ExitOnError)
+	syncNode := syncFlag.String("n", "", "full node to download headers and proofs from")
 
 	listenFlag := flag.NewFlagSet(listenFlagName, flag.ExitOnError)
-	listenAddr := listenFlag.String("a", "", "address")
+	listenPort := listenFlag.String("p", "", "port")
+	listenNode := listenFlag.String("n", "", "address other nodes can reach this node at")
+	listenSeeds := listenFlag.String("s", "", "comma separated seed nodes")
+	listenMiner := listenFlag.String("m", "", "miner address, node mines mempool transactions if set")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case sendFlagName:
-		err := sendFlag.Parse(os.Args[2:])
+		err := sendFlag.Parse(args[1:])
 		if err != nil {
 			sendFlag.Usage()
 			os.Exit(1)
 		}
-		cli.sendCmd(*sendFrom, *sendTo, *sendAmount)
+		locks := blockchain.PaymentLocks{LockTime: *sendLockTime, Until: *sendUntil, Blocks: uint32(*sendAfter)}
+		cli.sendCmd(*sendFrom, *sendTo, *sendAmount, *sendFee, locks, parseSigHash(*sendSigHash), *sendMine, *sendNode)
 	case printChainFlagName:
-		err := printChainFlag.Parse(os.Args[2:])
+		err := printChainFlag.Parse(args[1:])
 		if err != nil {
 			printChainFlag.Usage()
 			os.Exit(1)
 		}
 		cli.printChainCmd()
+	case mineGenesisName:
+		err := mineGenesisFlag.Parse(args[1:])
+		if err != nil {
+			mineGenesisFlag.Usage()
+			os.Exit(1)
+		}
+		cli.mineGenesisCmd()
 	case getBalanceFlagName:
-		err := getBalanceFlag.Parse(os.Args[2:])
+		err := getBalanceFlag.Parse(args[1:])
 		if err != nil {
 			getBalanceFlag.Usage()
 			os.Exit(1)
 		}
 		cli.getBalanceCmd(*getBalanceAddr)
 	case createWalletFlagName:
-		err := createWalletFlag.Parse(os.Args[2:])
+		err := createWalletFlag.Parse(args[1:])
 		if err != nil {
 			printChainFlag.Usage()
 			os.Exit(1)
 		}
 		cli.createWalletCmd()
 	case printWalletsFlagName:
-		err := printWalletsFlag.Parse(os.Args[2:])
+		err := printWalletsFlag.Parse(args[1:])
 		if err != nil {
 			printChainFlag.Usage()
 			os.Exit(1)
 		}
-		cli.printWalletsCmd()
+		cli.printWalletsCmd(*printWalletsKeys)
 	case listenFlagName:
-		err := listenFlag.Parse(os.Args[2:])
+		err := listenFlag.Parse(args[1:])
 		if err != nil {
 			printChainFlag.Usage()
 			os.Exit(1)
 		}
-		cli.listenCmd(*listenAddr)
+		cfg := network.Config{Port: *listenPort, Address: *listenNode, MinerAddress: *listenMiner}
+		if *listenSeeds != "" {
+			cfg.Seeds = strings.Split(*listenSeeds, ",")
+		}
+		cli.listenCmd(cfg)
+	case mineFlagName:
+		err := mineFlag.Parse(args[1:])
+		if err != nil {
+			mineFlag.Usage()
+			os.Exit(1)
+		}
+		cli.mineCmd(*mineAddr, *mineCount)
+	case supplyFlagName:
+		err := supplyFlag.Parse(args[1:])
+		if err != nil {
+			supplyFlag.Usage()
+			os.Exit(1)
+		}
+		cli.supplyCmd()
+	case syncFlagName:
+		err := syncFlag.Parse(args[1:])
+		if err != nil {
+			syncFlag.Usage()
+			os.Exit(1)
+		}
+		cli.syncCmd(*syncNode)
+	case createMultisigName:
+		err := createMultisigFlag.Parse(args[1:])
+		if err != nil {
+			createMultisigFlag.Usage()
+			os.Exit(1)
+		}
+		cli.createMultisigCmd(*createMultisigRequired, *createMultisigKeys)
+	case multisigTXName:
+		err := multisigTXFlag.Parse(args[1:])
+		if err != nil {
+			multisigTXFlag.Usage()
+			os.Exit(1)
+		}
+		cli.multisigTXCmd(*multisigTXFrom, *multisigTXTo, *multisigTXAmount, *multisigTXFee, *multisigTXFile)
+	case signMultisigName:
+		err := signMultisigFlag.Parse(args[1:])
+		if err != nil {
+			signMultisigFlag.Usage()
+			os.Exit(1)
+		}
+		cli.signMultisigCmd(*signMultisigFile, *signMultisigAddr, parseSigHash(*signMultisigSigHash))
+	case sendMultisigName:
+		err := sendMultisigFlag.Parse(args[1:])
+		if err != nil {
+			sendMultisigFlag.Usage()
+			os.Exit(1)
+		}
+		cli.sendMultisigCmd(*sendMultisigFile, *sendMultisigNode)
+	case initiateSwapName:
+		err := initiateSwapFlag.Parse(args[1:])
+		if err != nil {
+			initiateSwapFlag.Usage()
+			os.Exit(1)
+		}
+		cli.initiateSwapCmd(*initiateSwapFrom, *initiateSwapTo, *initiateSwapAmount, *initiateSwapFee, *initiateSwapDuration,
+			parseSigHash(*initiateSwapSigHash), *initiateSwapMine, *initiateSwapNode)
+	case participateSwapName:
+		err := participateSwapFlag.Parse(args[1:])
+		if err != nil {
+			participateSwapFlag.Usage()
+			os.Exit(1)
+		}
+		cli.participateSwapCmd(*participateSwapFrom, *participateSwapTo, *participateSwapAmount, *participateSwapFee, *participateSwapContract,
+			*participateSwapDuration, parseSigHash(*participateSwapSigHash), *participateSwapMine, *participateSwapNode)
+	case redeemSwapName:
+		err := redeemSwapFlag.Parse(args[1:])
+		if err != nil {
+			redeemSwapFlag.Usage()
+			os.Exit(1)
+		}
+		cli.redeemSwapCmd(*redeemSwapContract, *redeemSwapTX, *redeemSwapSecret, *redeemSwapFee, parseSigHash(*redeemSwapSigHash),
+			*redeemSwapMine, *redeemSwapNode)
+	case refundSwapName:
+		err := refundSwapFlag.Parse(args[1:])
+		if err != nil {
+			refundSwapFlag.Usage()
+			os.Exit(1)
+		}
+		cli.refundSwapCmd(*refundSwapContract, *refundSwapTX, *refundSwapFee, parseSigHash(*refundSwapSigHash), *refundSwapMine, *refundSwapNode)
+	case auditSwapName:
+		err := auditSwapFlag.Parse(args[1:])
+		if err != nil {
+			auditSwapFlag.Usage()
+			os.Exit(1)
+		}
+		cli.auditSwapCmd(*auditSwapContract, *auditSwapTX)
 	case helpFlagName:
 		fallthrough
 	default:
@@ -2,43 +2,89 @@ package cli
 
 import (
 	"bchain/internal/blockchain"
+	"bchain/internal/network"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 )
 
-func (cli *CLI) sendCmd(from string, to string, amount int64) {
+// mines the transaction locally if mine is set, otherwise passes it to the node
+func (cli *CLI) sendCmd(from string, to string, amount int64, fee int64, locks blockchain.PaymentLocks, hashType byte, mine bool, node string) {
 	if b, e := blockchain.ValidateAddress(from); !b || e != nil {
 		fmt.Println("ERROR: Sender address is not valid")
 	}
 	if b, e := blockchain.ValidateAddress(to); !b || e != nil {
 		fmt.Println("ERROR: Recipient address is not valid")
 	}
-	err := cli.createBlockChain(from)
+	if cli.light {
+		cli.sendLight(from, to, amount, fee, locks, hashType, mine, node)
+		return
+	}
+	err := cli.createBlockChain()
 	if err != nil {
-		fmt.Println("Something went wrong")
+		fmt.Println(err)
 		return
 	}
-	tx, err := cli.bc.NewUTXOTransaction(from, to, amount)
+	tx, err := cli.bc.NewUTXOTransaction(from, to, amount, fee, locks, hashType)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	coinbaseTx, err := blockchain.NewCoinbaseTX(from, "")
+	cli.publish(tx, from, mine, node)
+}
+
+// mines the transaction with reward to miner address if mine is set, otherwise passes it to the node
+func (cli *CLI) publish(tx *blockchain.Transaction, miner string, mine bool, node string) bool {
+	if !mine {
+		err := network.SendTransaction(node, tx, cli.bc, cli.db)
+		if err != nil {
+			fmt.Println(err)
+			return false
+		}
+		fmt.Println("Transaction is sent")
+		return true
+	}
+	err := cli.bc.MineBlock(miner, []*blockchain.Transaction{tx})
 	if err != nil {
 		fmt.Println(err)
+		return false
+	}
+	fmt.Println("Success")
+	return true
+}
+
+// light client can only pass the transaction to a full node
+func (cli *CLI) sendLight(from string, to string, amount int64, fee int64, locks blockchain.PaymentLocks, hashType byte, mine bool, node string) {
+	if mine {
+		fmt.Println("Light client can't mine transactions")
 		return
 	}
-	err = cli.bc.MineBlock([]*blockchain.Transaction{coinbaseTx, tx})
+	err := cli.createHeaderChain()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	fmt.Println("Success")
+	tx, err := cli.hc.NewTransaction(from, to, amount, fee, locks, hashType)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = network.SendTransactionLight(node, tx, cli.hc)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Transaction is sent")
 }
 
 func (cli *CLI) printChainCmd() {
-	err := cli.createBlockChain("")
+	err := cli.createBlockChain()
 	if err != nil {
-		fmt.Println("Something went wrong")
+		fmt.Println(err)
 		return
 	}
 	iter := cli.bc.Iterator()
@@ -49,6 +95,7 @@ func (cli *CLI) printChainCmd() {
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Version: %d\n", block.Version)
 		fmt.Printf("Prev. block: %x\n", block.PrevHash)
+		fmt.Printf("Bits: %08x\n", block.Nbits)
 		fmt.Printf("Valid: %t\n", block.Validate())
 		fmt.Println("Transactions:")
 		for _, tx := range block.Transactions {
@@ -64,56 +111,78 @@ func (cli *CLI) printChainCmd() {
 						continue
 					}
 					fmt.Printf("\tValue: %d\n", itx.Vout[txi.Vout].Value)
-					addr, err := blockchain.GetAddress(itx.Vout[txi.Vout].PubKeyHash, blockchain.BlockchainVersion)
-					if err != nil {
-						fmt.Println("\tCANT DISPLAY ADDRESS")
-						continue
-					}
-					fmt.Printf("\tAddress: %s\n", addr)
+					cli.printOwner(itx.Vout[txi.Vout])
 				}
 			}
 			fmt.Println("Outs:")
 			for i, txo := range tx.Vout {
 				fmt.Printf("%d:\n", i)
 				fmt.Printf("\tValue: %d\n", txo.Value)
-				addr, err := blockchain.GetAddress(txo.PubKeyHash, blockchain.BlockchainVersion)
-				if err != nil {
-					fmt.Println("\tCANT DISPLAY ADDRESS")
-					continue
-				}
-				fmt.Printf("\tAddress: %s\n", addr)
+				cli.printOwner(txo)
 			}
 		}
 		fmt.Printf("\n\n")
 	}
 }
 
-func (cli *CLI) getBalanceCmd(address string) {
-	err := cli.createBlockChain(address)
+// prints address of pay to pubkey hash and pay to script hash outputs and the script of others
+func (cli *CLI) printOwner(out blockchain.TXOutput) {
+	version := cli.params.AddressVersion
+	hash, ok := out.KeyHash()
+	if !ok {
+		version = cli.params.ScriptAddressVersion
+		hash, ok = out.ScriptHash()
+	}
+	if !ok {
+		fmt.Printf("\tScript: %s\n", blockchain.DisasmScript(out.ScriptPubKey))
+		return
+	}
+	addr, err := blockchain.GetAddress(hash, version)
 	if err != nil {
-		fmt.Println("Something went wrong")
+		fmt.Println("\tCANT DISPLAY ADDRESS")
 		return
 	}
-	var balance int64 = 0
+	fmt.Printf("\tAddress: %s\n", addr)
+}
+
+func (cli *CLI) getBalanceCmd(address string) {
 	pubKeyHash, err := blockchain.ExtractPubKeyHash(address)
 	if err != nil {
 		return
 	}
-	unspentTXOs := cli.bc.FindUnspentTXO(pubKeyHash)
-	for _, utx := range unspentTXOs {
-		balance += utx.Value
+	var balance, immature int64
+	if cli.light {
+		err = cli.createHeaderChain()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		balance, immature, err = cli.hc.Balance(pubKeyHash)
+	} else {
+		err = cli.createBlockChain()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		balance, immature, err = cli.utxoSet.Balance(pubKeyHash)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 	fmt.Printf("Balance is %d\n", balance)
+	fmt.Printf("Immature is %d\n", immature)
 }
 
 func (cli *CLI) printHelp() {
+	fmt.Println("Usage: [-net <mainnet|testnet|regtest>] [-genesis <spec file>] [-light] <command>")
 	fmt.Println("Commands:")
 
 	fmt.Printf("\t%s\n", getBalanceFlagName)
 	fmt.Printf("\t\tUsage: %s -a <address>\n", getBalanceFlagName)
 
 	fmt.Printf("\t%s\n", sendFlagName)
-	fmt.Printf("\t\tUsage: %s -a <address from> -t <address to> -a <amount>\n", sendFlagName)
+	fmt.Printf("\t\tUsage: %s -f <address from> -t <address to> -a <amount> [-fee <fee>] [-locktime <height or time>] [-until <height or time> | -after <blocks>] [-sighash <type>] [-m] [-n <node address>]\n", sendFlagName)
 
 	fmt.Printf("\t%s\n", printChainFlagName)
 	fmt.Printf("\t\tUsage: %s\n", printChainFlagName)
@@ -122,46 +191,516 @@ func (cli *CLI) printHelp() {
 	fmt.Printf("\t\tUsage: %s\n", createWalletFlagName)
 
 	fmt.Printf("\t%s\n", printWalletsFlagName)
-	fmt.Printf("\t\tUsage: %s\n", printChainFlagName)
+	fmt.Printf("\t\tUsage: %s [-k]\n", printWalletsFlagName)
+
+	fmt.Printf("\t%s\n", createMultisigName)
+	fmt.Printf("\t\tUsage: %s -m <required signatures> -k <pubkey1,pubkey2> (at most %d keys)\n",
+		createMultisigName, blockchain.MaxMultisigAddressKeys)
+
+	fmt.Printf("\t%s\n", multisigTXName)
+	fmt.Printf("\t\tUsage: %s -f <multisig address> -t <address to> -a <amount> [-fee <fee>] -o <file>\n", multisigTXName)
+
+	fmt.Printf("\t%s\n", signMultisigName)
+	fmt.Printf("\t\tUsage: %s -i <file> -a <wallet address> [-sighash <type>]\n", signMultisigName)
+
+	fmt.Printf("\t%s\n", sendMultisigName)
+	fmt.Printf("\t\tUsage: %s -i <file> [-n <node address>]\n", sendMultisigName)
+
+	fmt.Printf("\t%s\n", initiateSwapName)
+	fmt.Printf("\t\tUsage: %s -f <refund address> -t <participant address> -a <amount> [-fee <fee>] [-d <seconds>] [-sighash <type>] [-m] [-n <node address>]\n", initiateSwapName)
+
+	fmt.Printf("\t%s\n", participateSwapName)
+	fmt.Printf("\t\tUsage: %s -f <refund address> -t <initiator address> -a <amount> -c <initiator contract> [-fee <fee>] [-d <seconds>] [-sighash <type>] [-m] [-n <node address>]\n", participateSwapName)
+
+	fmt.Printf("\t%s\n", redeemSwapName)
+	fmt.Printf("\t\tUsage: %s -c <contract> -tx <contract transaction> -s <secret> [-fee <fee>] [-sighash <type>] [-m] [-n <node address>]\n", redeemSwapName)
+
+	fmt.Printf("\t%s\n", refundSwapName)
+	fmt.Printf("\t\tUsage: %s -c <contract> -tx <contract transaction> [-fee <fee>] [-sighash <type>] [-m] [-n <node address>]\n", refundSwapName)
+
+	fmt.Printf("\t%s\n", auditSwapName)
+	fmt.Printf("\t\tUsage: %s -c <contract> -tx <contract transaction>\n", auditSwapName)
+
+	fmt.Printf("\t%s\n", mineFlagName)
+	fmt.Printf("\t\tUsage: %s -a <address> [-c <count>]\n", mineFlagName)
+
+	fmt.Printf("\t%s\n", mineGenesisName)
+	fmt.Printf("\t\tUsage: -genesis <spec file> %s\n", mineGenesisName)
+
+	fmt.Printf("\t%s\n", supplyFlagName)
+	fmt.Printf("\t\tUsage: %s\n", supplyFlagName)
+
+	fmt.Printf("\t%s\n", syncFlagName)
+	fmt.Printf("\t\tUsage: -light %s [-n <node address>]\n", syncFlagName)
 
 	fmt.Printf("\t%s\n", listenFlagName)
-	fmt.Printf("\t\tUsage: %s -a <address>\n", listenFlagName)
+	fmt.Printf("\t\tUsage: %s -p <port> -n <node address> -s <seed1,seed2> -m <miner address>\n", listenFlagName)
 }
 
 func (cli *CLI) createWalletCmd() {
-	wallets, err := blockchain.NewWallets(blockchain.WalletFile)
+	wallets, err := blockchain.NewWallets(cli.params.WalletFile)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	address, err := wallets.CreateWallet()
+	address, err := wallets.CreateWallet(cli.params.AddressVersion)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	err = wallets.SaveToFile(blockchain.WalletFile)
+	err = wallets.SaveToFile(cli.params.WalletFile)
 	if err != nil {
 		fmt.Println(err)
 	}
 	fmt.Printf("Your new address: %s\n", address)
 }
 
-func (cli *CLI) printWalletsCmd() {
-	wallets, err := blockchain.NewWallets(blockchain.WalletFile)
+// mines blocks without transactions, so coinbase outputs of a new chain can mature
+func (cli *CLI) mineCmd(address string, count int) {
+	err := cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := 0; i < count; i++ {
+		err = cli.bc.MineBlock(address, []*blockchain.Transaction{})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	fmt.Println("Success")
+}
+
+func (cli *CLI) supplyCmd() {
+	err := cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	supply, err := cli.bc.Supply()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Height: %d\n", supply.Height)
+	fmt.Printf("Unspent: %d\n", supply.Unspent)
+	fmt.Printf("Scheduled: %d\n", supply.Scheduled)
+	fmt.Printf("Max supply: %d\n", supply.Max)
+	fmt.Printf("Valid: %t\n", supply.IsValid())
+}
+
+func (cli *CLI) printWalletsCmd(keys bool) {
+	wallets, err := blockchain.NewWallets(cli.params.WalletFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for a, w := range wallets.Wallets {
+		if keys {
+			fmt.Printf("%s %x\n", a, w.PublicKey)
+		} else {
+			fmt.Println(a)
+		}
+	}
+	for a, script := range wallets.Scripts {
+		fmt.Printf("%s %s\n", a, blockchain.DisasmScript(script))
+	}
+}
+
+func (cli *CLI) listenCmd(cfg network.Config) {
+	err := cli.createBlockChain()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	for a := range wallets.Wallets {
-		fmt.Println(a)
+	network.StartServer(cli.db, cli.bc, cfg)
+}
+
+// downloads headers and proven transactions of every wallet address
+func (cli *CLI) syncCmd(node string) {
+	if !cli.light {
+		fmt.Println("Full node synchronizes with listen command")
+		return
+	}
+	err := cli.createHeaderChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	wallets, err := blockchain.NewWallets(cli.params.WalletFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	pubKeyHashes := [][]byte{}
+	addresses := []string{}
+	for address := range wallets.Wallets {
+		addresses = append(addresses, address)
+	}
+	for address := range wallets.Scripts {
+		addresses = append(addresses, address)
+	}
+	for _, address := range addresses {
+		pubKeyHash, err := blockchain.ExtractPubKeyHash(address)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		pubKeyHashes = append(pubKeyHashes, pubKeyHash)
+	}
+	err = network.SyncLight(node, cli.hc, pubKeyHashes)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
+	fmt.Printf("Synchronized headers up to height %d\n", cli.hc.Tip().Height)
 }
 
-func (cli *CLI) listenCmd(address string) {
-	err := cli.createBlockChain(address)
+// creates address which needs signatures of required number of the keys, its script is kept in wallets
+func (cli *CLI) createMultisigCmd(required int, keys string) {
+	pubKeys := [][]byte{}
+	for _, key := range strings.Split(keys, ",") {
+		pubKey, err := hex.DecodeString(key)
+		if err != nil {
+			fmt.Println("ERROR: Public key is not valid hex")
+			return
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	wallets, err := blockchain.NewWallets(cli.params.WalletFile)
 	if err != nil {
-		fmt.Println("Something went wrong")
+		fmt.Println(err)
+		return
+	}
+	address, err := wallets.CreateMultisig(required, pubKeys, cli.params.ScriptAddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = wallets.SaveToFile(cli.params.WalletFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Multisig address: %s\n", address)
+	fmt.Printf("Redeem script: %x\n", wallets.Scripts[address])
+}
+
+// writes unsigned transaction spending outputs of the multisig address to the file
+func (cli *CLI) multisigTXCmd(from string, to string, amount int64, fee int64, file string) {
+	err := cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	mtx, err := cli.bc.NewMultisigTransaction(from, to, amount, fee)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = mtx.SaveToFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Transaction %x is written to %s\n", mtx.Transaction.ID, file)
+}
+
+// adds signatures of the wallet to the transaction file
+func (cli *CLI) signMultisigCmd(file string, address string, hashType byte) {
+	mtx, err := blockchain.LoadMultisigTX(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	wallets, err := blockchain.NewWallets(cli.params.WalletFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	wallet, ok := wallets.Wallets[address]
+	if !ok {
+		fmt.Println("ERROR: Wallet is not found")
+		return
+	}
+	signed, err := mtx.Sign(wallet, hashType)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = mtx.SaveToFile(file)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Signed %d inputs\n", signed)
+}
+
+// builds scriptSigs from collected signatures and passes the transaction to the node
+func (cli *CLI) sendMultisigCmd(file string, node string) {
+	mtx, err := blockchain.LoadMultisigTX(file)
+	if err != nil {
+		fmt.Println(err)
 		return
 	}
-	fmt.Println("Not implemented")
+	tx, err := mtx.Finalize()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if ok, err := cli.bc.VerifyTransaction(tx); err != nil || !ok {
+		fmt.Println("ERROR: Transaction is not valid")
+		return
+	}
+	err = network.SendTransaction(node, tx, cli.bc, cli.db)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Transaction is sent")
+}
+
+// pays to contract with new secret, which is printed and must be kept until the participant pays to its contract
+func (cli *CLI) initiateSwapCmd(from string, to string, amount int64, fee int64, duration uint64, hashType byte, mine bool, node string) {
+	lockTime, err := blockchain.SwapLockTime(time.Now().Unix(), duration)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	secret := make([]byte, blockchain.SecretSize)
+	_, err = rand.Read(secret)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	secretHash := sha256.Sum256(secret)
+	if !cli.fundContract(secretHash[:], from, to, amount, fee, lockTime, hashType, mine, node) {
+		return
+	}
+	fmt.Printf("Secret: %x\n", secret)
+	fmt.Printf("Secret hash: %x\n", secretHash)
+}
+
+// pays to contract with the secret hash of the initiator contract, which must be audited on the other
+// chain first. its lock time must be well before the one of the initiator contract, so the initiator
+// can't redeem it and refund its own contract
+func (cli *CLI) participateSwapCmd(from string, to string, amount int64, fee int64, contractHex string, duration uint64, hashType byte, mine bool, node string) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		fmt.Println("ERROR: Contract is not valid hex")
+		return
+	}
+	initiator, err := blockchain.ExtractHTLC(contract)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	now := time.Now().Unix()
+	lockTime, err := blockchain.SwapLockTime(now, duration)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = blockchain.CheckParticipantLock(initiator, lockTime, now)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	cli.fundContract(initiator.SecretHash, from, to, amount, fee, lockTime, hashType, mine, node)
+}
+
+// pays amount from the refund address to contract redeemable by the recipient,
+// it can be refunded from the lock time
+func (cli *CLI) fundContract(secretHash []byte, refund string, recipient string, amount int64, fee int64, lockTime uint64, hashType byte, mine bool, node string) bool {
+	err := cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	contract, err := blockchain.NewHTLC(secretHash, recipient, refund, lockTime)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	address, err := contract.Address(cli.params.ScriptAddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	tx, err := cli.bc.NewUTXOTransaction(refund, address, amount, fee, blockchain.PaymentLocks{}, hashType)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	if !cli.publish(tx, refund, mine, node) {
+		return false
+	}
+	fmt.Printf("Contract address: %s\n", address)
+	fmt.Printf("Contract: %x\n", contract.Script())
+	fmt.Printf("Contract transaction: %x\n", tx.ID)
+	fmt.Printf("Refund lock time: %s\n", formatLockTime(contract.LockTime))
+	return true
+}
+
+// spends the contract output with the secret, which becomes visible to the other side of the swap
+func (cli *CLI) redeemSwapCmd(contractHex string, txIDHex string, secretHex string, fee int64, hashType byte, mine bool, node string) {
+	secret, err := hex.DecodeString(secretHex)
+	if err != nil {
+		fmt.Println("ERROR: Secret is not valid hex")
+		return
+	}
+	cli.spendContract(contractHex, txIDHex, func(contract []byte, txID []byte) (*blockchain.Transaction, error) {
+		return cli.bc.NewRedeemTransaction(contract, txID, secret, fee, hashType)
+	}, mine, node)
+}
+
+// returns the contract output to the sender after its lock time
+func (cli *CLI) refundSwapCmd(contractHex string, txIDHex string, fee int64, hashType byte, mine bool, node string) {
+	cli.spendContract(contractHex, txIDHex, func(contract []byte, txID []byte) (*blockchain.Transaction, error) {
+		return cli.bc.NewRefundTransaction(contract, txID, fee, hashType)
+	}, mine, node)
+}
+
+func (cli *CLI) spendContract(contractHex string, txIDHex string, newTX func([]byte, []byte) (*blockchain.Transaction, error), mine bool, node string) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		fmt.Println("ERROR: Contract is not valid hex")
+		return
+	}
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil {
+		fmt.Println("ERROR: Transaction id is not valid hex")
+		return
+	}
+	err = cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tx, err := newTX(contract, txID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ok, err := cli.bc.VerifyTransaction(tx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !ok {
+		fmt.Println("ERROR: Transaction is not valid")
+		return
+	}
+	keyHash, _ := tx.Vout[0].KeyHash()
+	miner, err := blockchain.GetAddress(keyHash, cli.params.AddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if cli.publish(tx, miner, mine, node) {
+		fmt.Printf("Transaction: %x\n", tx.ID)
+	}
+}
+
+// prints terms of the contract and state of its output, secret is printed once the contract is redeemed
+func (cli *CLI) auditSwapCmd(contractHex string, txIDHex string) {
+	contract, err := hex.DecodeString(contractHex)
+	if err != nil {
+		fmt.Println("ERROR: Contract is not valid hex")
+		return
+	}
+	txID, err := hex.DecodeString(txIDHex)
+	if err != nil {
+		fmt.Println("ERROR: Transaction id is not valid hex")
+		return
+	}
+	c, err := blockchain.ExtractHTLC(contract)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = cli.createBlockChain()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	tx, vout, err := cli.bc.FindContractOutput(contract, txID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	address, err := c.Address(cli.params.ScriptAddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	recipient, err := blockchain.GetAddress(c.RecipientHash, cli.params.AddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	refund, err := blockchain.GetAddress(c.RefundHash, cli.params.AddressVersion)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Contract address: %s\n", address)
+	fmt.Printf("Value: %d\n", tx.Vout[vout].Value)
+	fmt.Printf("Recipient: %s\n", recipient)
+	fmt.Printf("Refund: %s\n", refund)
+	fmt.Printf("Secret hash: %x\n", c.SecretHash)
+	fmt.Printf("Refund lock time: %s\n", formatLockTime(c.LockTime))
+	out, err := cli.utxoSet.FindOutput(tx.ID, vout)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if out != nil {
+		fmt.Println("State: unspent")
+		return
+	}
+	secret, err := cli.bc.FindContractSecret(contract, txID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if secret == nil {
+		fmt.Println("State: refunded")
+		return
+	}
+	fmt.Println("State: redeemed")
+	fmt.Printf("Secret: %x\n", secret)
+}
+
+// finds proof of work of the genesis spec and prints the spec with its nonce and hash,
+// the printed spec can be passed to -genesis
+func (cli *CLI) mineGenesisCmd() {
+	block, err := cli.params.MineGenesis()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	spec := *cli.params.Genesis
+	spec.Nonce = block.Nonce
+	spec.Hash = hex.EncodeToString(block.Hash)
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+// lock times of swap contracts are unix times, older contracts may have heights
+func formatLockTime(lockTime int64) string {
+	if lockTime < blockchain.LockTimeThreshold {
+		return fmt.Sprintf("height %d", lockTime)
+	}
+	return fmt.Sprintf("%d (%s)", lockTime, time.Unix(lockTime, 0).UTC().Format(time.RFC3339))
 }
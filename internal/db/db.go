@@ -7,6 +7,21 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const nodesTable = `
+	CREATE TABLE IF NOT EXISTS nodes ( 
+		address STRING UNIQUE,
+		version NUMBER,
+		last_seen NUMBER DEFAULT 0
+	)`
+
+// transactions of the light client wallet with hashes of blocks containing them
+const walletTxsTable = `
+	CREATE TABLE IF NOT EXISTS wallettxs ( 
+		id BLOB UNIQUE,
+		tx BLOB,
+		block BLOB
+	)`
+
 type DB struct {
 	db *sql.DB
 }
@@ -36,14 +51,47 @@ func NewDb(blocksPath string) (db *DB, err error) {
 		return nil, err
 	}
 	_, err = db.db.Exec(`
-	CREATE TABLE IF NOT EXISTS nodes ( 
-		address STRING UNIQUE,
-		version NUMBER
-	)`,
+		CREATE TABLE IF NOT EXISTS chainwork ( 
+			hash BLOB UNIQUE,
+			work BLOB
+		)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.db.Exec(`
+		CREATE TABLE IF NOT EXISTS undo ( 
+			hash BLOB UNIQUE,
+			undo BLOB
+		)`,
 	)
 	if err != nil {
 		return nil, err
 	}
+	_, err = db.db.Exec(`
+		CREATE TABLE IF NOT EXISTS headers ( 
+			hash BLOB UNIQUE,
+			header BLOB
+		)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.db.Exec(walletTxsTable)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.db.Exec(nodesTable)
+	if err != nil {
+		return nil, err
+	}
+	// known nodes are only a cache, so table with an old schema is recreated
+	if _, err = db.db.Exec("SELECT last_seen FROM nodes LIMIT 1"); err != nil {
+		err = db.ClearKnownNodes()
+		if err != nil {
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -69,11 +117,50 @@ func (db *DB) AddBlock(hash []byte, block []byte) error {
 	return err
 }
 
+// stores cumulative work of the chain ending with the block
+func (db *DB) AddChainWork(hash []byte, work []byte) error {
+	_, err := db.db.Exec("REPLACE INTO chainwork ( hash, work ) VALUES ( $1, $2 )", hash, work)
+	return err
+}
+
+func (db *DB) GetChainWork(hash []byte) ([]byte, error) {
+	rows, err := db.db.Query("SELECT work FROM chainwork WHERE hash = $1", hash)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var work []byte
+		rows.Scan(&work)
+		return work, nil
+	}
+	return []byte{}, nil
+}
+
 func (db *DB) UpdateLast(hash []byte) error {
 	_, err := db.db.Exec("REPLACE INTO blocks ( hash, block ) VALUES ( $1, $2 )", "l", hash)
 	return err
 }
 
+func (db *DB) UpdateGenesis(hash []byte) error {
+	_, err := db.db.Exec("REPLACE INTO blocks ( hash, block ) VALUES ( $1, $2 )", "g", hash)
+	return err
+}
+
+func (db *DB) GetGenesis() ([]byte, error) {
+	rows, err := db.db.Query("SELECT block FROM blocks WHERE hash = $1", "g")
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash []byte
+		rows.Scan(&hash)
+		return hash, nil
+	}
+	return []byte{}, nil
+}
+
 func (db *DB) GetBlock(hash []byte) ([]byte, error) {
 	rows, err := db.db.Query("SELECT block FROM blocks WHERE hash = $1", hash)
 	if err != nil {
@@ -102,11 +189,91 @@ func (db *DB) GetLast() ([]byte, error) {
 	return []byte{}, nil
 }
 
+func (db *DB) AddHeader(hash []byte, header []byte) error {
+	_, err := db.db.Exec("REPLACE INTO headers ( hash, header ) VALUES ( $1, $2 )", hash, header)
+	return err
+}
+
+func (db *DB) GetHeader(hash []byte) ([]byte, error) {
+	rows, err := db.db.Query("SELECT header FROM headers WHERE hash = $1", hash)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var header []byte
+		rows.Scan(&header)
+		return header, nil
+	}
+	return []byte{}, nil
+}
+
+func (db *DB) UpdateLastHeader(hash []byte) error {
+	_, err := db.db.Exec("REPLACE INTO headers ( hash, header ) VALUES ( $1, $2 )", "l", hash)
+	return err
+}
+
+func (db *DB) GetLastHeader() ([]byte, error) {
+	rows, err := db.db.Query("SELECT header FROM headers WHERE hash = $1", "l")
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash []byte
+		rows.Scan(&hash)
+		return hash, nil
+	}
+	return []byte{}, nil
+}
+
+func (db *DB) AddWalletTx(id []byte, tx []byte, blockHash []byte) error {
+	_, err := db.db.Exec("REPLACE INTO wallettxs ( id, tx, block ) VALUES ( $1, $2, $3 )", id, tx, blockHash)
+	return err
+}
+
+func (db *DB) ClearWalletTxs() error {
+	_, err := db.db.Exec("DROP TABLE IF EXISTS wallettxs")
+	if err != nil {
+		return err
+	}
+	_, err = db.db.Exec(walletTxsTable)
+	return err
+}
+
+func (db *DB) WalletTxsIterator() (Iterator[WalletTxElem], error) {
+	rows, err := db.db.Query("SELECT tx, block FROM wallettxs")
+	if err != nil {
+		return nil, err
+	}
+	return &WalletTxsIterator{rows: rows}, nil
+}
+
 func (db *DB) AddTXO(hash []byte, txo []byte) error {
 	_, err := db.db.Exec("REPLACE INTO utxoset ( hash, utxo ) VALUES ( $1, $2 )", hash, txo)
 	return err
 }
 
+// stores outputs spent by the block, so it can be disconnected from utxo set
+func (db *DB) AddUndo(blockHash []byte, undo []byte) error {
+	_, err := db.db.Exec("REPLACE INTO undo ( hash, undo ) VALUES ( $1, $2 )", blockHash, undo)
+	return err
+}
+
+func (db *DB) GetUndo(blockHash []byte) ([]byte, error) {
+	rows, err := db.db.Query("SELECT undo FROM undo WHERE hash = $1", blockHash)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var undo []byte
+		rows.Scan(&undo)
+		return undo, nil
+	}
+	return []byte{}, nil
+}
+
 func (db *DB) UpdateUTXOBlock(blockHash []byte) error {
 	_, err := db.db.Exec("REPLACE INTO utxoset ( hash, utxo ) VALUES ( $1, $2 )", "b", blockHash)
 	return err
@@ -159,14 +326,30 @@ func (db *DB) UTXOiterator() (Iterator[UTXOsetElem], error) {
 	return &TXOiterator{rows: rows}, nil
 }
 
-func (db *DB) AddKnownNode(address string, version int32) error {
-	if address == "v" {
+// adds node or updates already known one keeping the latest time it was seen
+func (db *DB) AddKnownNode(address string, version int32, lastSeen int64) error {
+	if address == "v" || address == "" {
 		return errors.New("INVALID ADDRESS")
 	}
-	_, err := db.db.Exec("REPLACE INTO nodes ( address, version ) VALUES ( $1, $2 )", address, version)
+	_, err := db.db.Exec(`
+		INSERT INTO nodes ( address, version, last_seen ) VALUES ( $1, $2, $3 )
+		ON CONFLICT ( address ) DO UPDATE SET
+			version = MAX(version, excluded.version),
+			last_seen = MAX(last_seen, excluded.last_seen)`,
+		address, version, lastSeen,
+	)
 	return err
 }
 
+func (db *DB) HasKnownNode(address string) (bool, error) {
+	rows, err := db.db.Query("SELECT address FROM nodes WHERE address = $1 AND address != $2", address, "v")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), nil
+}
+
 // gets blockchain version of known nodes lists
 func (db *DB) GetVersion() (int32, error) {
 	rows, err := db.db.Query("SELECT version FROM nodes WHERE address = $1", "v")
@@ -192,20 +375,16 @@ func (db *DB) ClearKnownNodes() error {
 	if err != nil {
 		return err
 	}
-	_, err = db.db.Exec(`
-	CREATE TABLE IF NOT EXISTS nodes ( 
-		address STRING UNIQUE,
-		version NUMBER
-	)`,
-	)
+	_, err = db.db.Exec(nodesTable)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// iterates over known nodes starting from the most recently seen
 func (db *DB) KnownNodesIterator() (Iterator[KnownNodesElem], error) {
-	rows, err := db.db.Query("SELECT (address, version) FROM utxoset WHERE address != $1", "v")
+	rows, err := db.db.Query("SELECT address, version, last_seen FROM nodes WHERE address != $1 ORDER BY last_seen DESC", "v")
 	if err != nil {
 		return nil, err
 	}
@@ -8,6 +8,8 @@ type Iterator[T any] interface {
 	Next() bool
 	// must call next before calling get
 	Get() T
+	// releases rows if iteration is stopped before next returns false
+	Close() error
 }
 
 type TXOiterator struct {
@@ -24,8 +26,18 @@ type KnownNodesIterator struct {
 }
 
 type KnownNodesElem struct {
-	Address string
-	Version int32
+	Address  string
+	Version  int32
+	LastSeen int64
+}
+
+type WalletTxsIterator struct {
+	rows *sql.Rows
+}
+
+type WalletTxElem struct {
+	Tx        []byte
+	BlockHash []byte
 }
 
 func (iter *TXOiterator) Next() bool {
@@ -36,6 +48,10 @@ func (iter *TXOiterator) Next() bool {
 	return true
 }
 
+func (iter *TXOiterator) Close() error {
+	return iter.rows.Close()
+}
+
 func (iter *TXOiterator) Get() UTXOsetElem {
 	res := UTXOsetElem{}
 	iter.rows.Scan(&res.TxHash, &res.Txo)
@@ -50,8 +66,30 @@ func (iter *KnownNodesIterator) Next() bool {
 	return true
 }
 
+func (iter *KnownNodesIterator) Close() error {
+	return iter.rows.Close()
+}
+
 func (iter *KnownNodesIterator) Get() KnownNodesElem {
 	res := KnownNodesElem{}
-	iter.rows.Scan(&res.Address, &res.Version)
+	iter.rows.Scan(&res.Address, &res.Version, &res.LastSeen)
+	return res
+}
+
+func (iter *WalletTxsIterator) Next() bool {
+	if !iter.rows.Next() {
+		iter.rows.Close()
+		return false
+	}
+	return true
+}
+
+func (iter *WalletTxsIterator) Close() error {
+	return iter.rows.Close()
+}
+
+func (iter *WalletTxsIterator) Get() WalletTxElem {
+	res := WalletTxElem{}
+	iter.rows.Scan(&res.Tx, &res.BlockHash)
 	return res
 }
@@ -0,0 +1,145 @@
+package network
+
+import (
+	database "bchain/internal/db"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	maxPeers    = 8
+	maxAddrSize = 1000
+	// nodes which were not seen for longer are not shared with others
+	addrMaxAge = 3 * time.Hour
+	// number of nodes new addresses are relayed to
+	relayFanout = 2
+)
+
+// nodes used to join the network when not enough nodes are known
+var seeds []string
+
+// returns not connected nodes to fill free peer slots, the most recently seen known nodes go first, then seeds
+func selectPeers(db *database.DB) []string {
+	selected := []string{}
+	free := maxPeers - len(connectedPeers())
+	isCandidate := func(addr string) bool {
+		return addr != nodeAddress && !contains(selected, addr) && !isConnected(addr)
+	}
+	iter, err := db.KnownNodesIterator()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		for len(selected) < free && iter.Next() {
+			node := iter.Get()
+			if isCandidate(node.Address) {
+				selected = append(selected, node.Address)
+			}
+		}
+		iter.Close()
+	}
+	for _, seed := range seeds {
+		if len(selected) >= free {
+			break
+		}
+		if isCandidate(seed) {
+			selected = append(selected, seed)
+		}
+	}
+	return selected
+}
+
+// returns up to limit recently seen nodes
+func knownAddrs(db *database.DB, limit int) ([]nodeAddr, error) {
+	list := []nodeAddr{}
+	iter, err := db.KnownNodesIterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	oldest := time.Now().Add(-addrMaxAge).Unix()
+	for len(list) < limit && iter.Next() {
+		node := iter.Get()
+		if node.LastSeen < oldest {
+			break
+		}
+		list = append(list, nodeAddr{Addr: node.Address, LastSeen: node.LastSeen})
+	}
+	return list, nil
+}
+
+// saves addresses and returns the ones which were not known before
+func storeAddrs(db *database.DB, list []nodeAddr) ([]nodeAddr, error) {
+	fresh := []nodeAddr{}
+	now := time.Now().Unix()
+	for _, a := range list {
+		if a.Addr == "" || a.Addr == nodeAddress {
+			continue
+		}
+		// timestamps from the future are not trusted
+		if a.LastSeen > now {
+			a.LastSeen = now
+		}
+		known, err := db.HasKnownNode(a.Addr)
+		if err != nil {
+			return nil, err
+		}
+		err = db.AddKnownNode(a.Addr, 0, a.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		if !known {
+			fresh = append(fresh, a)
+		}
+	}
+	return fresh, nil
+}
+
+func requestAddrs(p *peer, db *database.DB) error {
+	resp := new(addr)
+	err := p.request("getaddr", getaddr{Max: maxAddrSize}, "addr", resp)
+	if err != nil {
+		return err
+	}
+	if len(resp.AddrList) > maxAddrSize {
+		return errors.New("TOO MANY ADDRESSES")
+	}
+	_, err = storeAddrs(db, resp.AddrList)
+	return err
+}
+
+// sends addresses to a few random peers except the advertised ones
+func relayAddrs(list []nodeAddr) {
+	candidates := connectedPeers()
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	sent := 0
+	for _, c := range candidates {
+		if sent >= relayFanout {
+			break
+		}
+		advertised := false
+		for _, a := range list {
+			advertised = advertised || a.Addr == c.Addr()
+		}
+		if advertised {
+			continue
+		}
+		if err := c.send("addr", addr{AddrList: list}); err != nil {
+			fmt.Printf("addr to %s: %s\n", c.Addr(), err)
+			continue
+		}
+		sent++
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -3,47 +3,375 @@ package network
 import (
 	"bchain/internal/blockchain"
 	database "bchain/internal/db"
-	"bytes"
-	"encoding/gob"
+	"errors"
 	"fmt"
-	"io"
-	"net"
 	"strconv"
 	"time"
 )
 
-type handlerFunc func(net.Conn, []byte, *blockchain.Blockchain, *database.DB)
+type handlerFunc func(*peer, []byte, *blockchain.Blockchain, *database.DB) error
 
 var handlers = map[string]handlerFunc{
-	"version": handleVersion,
+	"version":    handleVersion,
+	"verack":     handleVerack,
+	"getblocks":  handleGetBlocks,
+	"getdata":    handleGetData,
+	"getheaders": handleGetHeaders,
+	"getaddr":    handleGetAddr,
+	"addr":       handleAddr,
+	"inv":        handleInv,
+	"tx":         handleTx,
+	"block":      handleBlock,
+	"getproofs":  handleGetProofs,
+	"notfound":   handleNotFound,
 }
 
-func handleVersion(conn net.Conn, request []byte, bc *blockchain.Blockchain, db *database.DB) {
-	payload := request[12:]
+// checks compatibility of the peer and answers with own version if it was not sent yet and verack
+func handleVersion(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
 	req := new(version)
-	decoder := gob.NewDecoder(bytes.NewReader(payload))
-	decoder.Decode(req)
+	err := decodePayload(request, req)
+	if err != nil {
+		p.close()
+		return err
+	}
 	fmt.Printf("got %s %s %s %s\n",
 		req.Addr, strconv.FormatUint(req.Height, 10),
 		strconv.FormatInt(req.Timestamp, 10), strconv.FormatInt(int64(req.Version), 10),
 	)
-	db.AddKnownNode(req.Addr, req.Version)
-	h, err := bc.GetBestHeight()
+	p.mu.Lock()
+	duplicate := p.versionReceived
+	p.versionReceived = true
+	versionSent := p.versionSent
+	p.mu.Unlock()
+	if duplicate {
+		p.close()
+		return errors.New("DUPLICATE VERSION")
+	}
+	if req.Version < minProtocolVersion {
+		p.close()
+		return fmt.Errorf("INCOMPATIBLE PROTOCOL VERSION %d", req.Version)
+	}
+	if req.Addr != "" && req.Addr == nodeAddress {
+		p.close()
+		return errors.New("CONNECTED TO SELF")
+	}
+	p.mu.Lock()
+	if p.addr == "" {
+		p.addr = req.Addr
+	}
+	p.height = req.Height
+	p.protoVersion = req.Version
+	if p.protoVersion > protocolVersion {
+		p.protoVersion = protocolVersion
+	}
+	p.mu.Unlock()
+	if !versionSent {
+		err = p.sendVersion(bc)
+		if err != nil {
+			return err
+		}
+	}
+	err = p.send("verack", verack{Version: protocolVersion})
+	if err != nil {
+		return err
+	}
+	if p.completeHandshake() {
+		return onHandshake(p, bc, db)
+	}
+	return nil
+}
+
+func handleVerack(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(verack)
+	err := decodePayload(request, req)
 	if err != nil {
-		return
+		p.close()
+		return err
+	}
+	p.mu.Lock()
+	duplicate := p.verackReceived
+	p.verackReceived = true
+	p.mu.Unlock()
+	if duplicate {
+		p.close()
+		return errors.New("DUPLICATE VERACK")
 	}
-	resp := version{
-		Version:   blockchain.BlockchainVersion,
-		Height:    h,
-		Timestamp: time.Now().Unix(),
-		Addr:      conn.RemoteAddr().String(),
+	if p.completeHandshake() {
+		return onHandshake(p, bc, db)
+	}
+	return nil
+}
+
+// remembers the peer and downloads its blocks if it has longer chain
+func onHandshake(p *peer, bc *blockchain.Blockchain, db *database.DB) error {
+	if p.light {
+		return nil
 	}
-	response := new(bytes.Buffer)
-	response.Write(commandToBytes("version"))
-	encoder := gob.NewEncoder(response)
-	err = encoder.Encode(&resp)
+	addr := p.Addr()
+	if addr != "" {
+		known, err := db.HasKnownNode(addr)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		err = db.AddKnownNode(addr, p.protoVersion, now)
+		if err != nil {
+			return err
+		}
+		if !known {
+			go relayAddrs([]nodeAddr{{Addr: addr, LastSeen: now}})
+		}
+	}
+	if needsSync(bc, p.Height()) {
+		go func() {
+			if err := syncWith([]*peer{p}, bc); err != nil {
+				fmt.Printf("sync with %s: %s\n", addr, err)
+			}
+		}()
+	}
+	return nil
+}
+
+func handleGetBlocks(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(getblocks)
+	err := decodePayload(request, req)
 	if err != nil {
-		return
+		return err
+	}
+	hashes := bc.GetBlockHashesAfter(req.BlockLocatorHashes, req.LastHash, maxInvSize)
+	return p.send("inv", inv{Type: invTypeBlock, Inventory: hashes})
+}
+
+func handleGetHeaders(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(getheaders)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	headers, err := bc.GetHeadersAfter(req.BlockLocatorHashes, req.LastHash, maxHeadersSize)
+	if err != nil {
+		return err
+	}
+	serialized := make([][]byte, len(headers))
+	for i := range headers {
+		serialized[i], err = headers[i].Serialize()
+		if err != nil {
+			return err
+		}
+	}
+	return p.send("headers", headersMsg{Headers: serialized})
+}
+
+func handleGetData(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(getdata)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	if len(req.Inventory) > maxInvSize {
+		return errors.New("TOO MANY INVENTORY ITEMS")
+	}
+	missing := [][]byte{}
+	switch req.Type {
+	case invTypeBlock:
+		for _, hash := range req.Inventory {
+			serialized, err := db.GetBlock(hash)
+			if err != nil {
+				return err
+			}
+			if len(serialized) == 0 {
+				missing = append(missing, hash)
+				continue
+			}
+			err = p.send("block", block{Block: serialized})
+			if err != nil {
+				return err
+			}
+		}
+	case invTypeTx:
+		for _, id := range req.Inventory {
+			tx, ok := bc.Mempool().Get(id)
+			if !ok {
+				missing = append(missing, id)
+				continue
+			}
+			serialized, err := tx.Serialize()
+			if err != nil {
+				return err
+			}
+			err = p.send("tx", txMsg{Transaction: serialized})
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("UNKNOWN INVENTORY TYPE")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	// requester doesn't have to wait for timeout
+	return p.send("notfound", notfound{Type: req.Type, Inventory: missing})
+}
+
+// notfound which is not an answer to a request, nothing to do
+func handleNotFound(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	return nil
+}
+
+// starts synchronization if peer announces unknown blocks and requests unknown transactions
+func handleInv(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(inv)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	if len(req.Inventory) > maxInvSize {
+		return errors.New("TOO MANY INVENTORY ITEMS")
+	}
+	switch req.Type {
+	case invTypeBlock:
+		unknown := [][]byte{}
+		for _, hash := range req.Inventory {
+			if !bc.HasBlock(hash) && !bc.Orphans().Has(hash) {
+				unknown = append(unknown, hash)
+			}
+		}
+		if len(unknown) == 0 {
+			return nil
+		}
+		return p.send("getdata", getdata{Type: invTypeBlock, Inventory: unknown})
+	case invTypeTx:
+		unknown := [][]byte{}
+		for _, id := range req.Inventory {
+			if !bc.Mempool().Has(id) {
+				unknown = append(unknown, id)
+			}
+		}
+		if len(unknown) == 0 {
+			return nil
+		}
+		return p.send("getdata", getdata{Type: invTypeTx, Inventory: unknown})
+	default:
+		return errors.New("UNKNOWN INVENTORY TYPE")
+	}
+}
+
+// adds transaction to the mempool and announces it to the other peers
+func handleTx(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(txMsg)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	tx, err := blockchain.DeserializeTransaction(req.Transaction)
+	if err != nil {
+		return err
+	}
+	if bc.Mempool().Has(tx.ID) {
+		return nil
+	}
+	err = bc.Mempool().Add(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added transaction %x to mempool\n", tx.ID)
+	relayTransaction(tx.ID, p)
+	return nil
+}
+
+// handles blocks which were not requested by sync, missing parents
+// of orphan blocks are requested from the same peer
+func handleBlock(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(block)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	b, err := blockchain.DeserializeBlock(req.Block)
+	if err != nil {
+		return err
+	}
+	err = bc.AddBlock(b)
+	if errors.Is(err, blockchain.ErrOrphanBlock) {
+		missing := bc.Orphans().MissingParent(b.Hash)
+		fmt.Printf("orphan block %x, requesting parent %x\n", b.Hash, missing)
+		return p.send("getdata", getdata{Type: invTypeBlock, Inventory: [][]byte{missing}})
+	}
+	if err != nil {
+		return err
+	}
+	// height reported by the peer is trusted only for valid blocks
+	if b.Height > p.Height() {
+		p.setHeight(b.Height)
+	}
+	fmt.Printf("added block %x\n", b.Hash)
+	return nil
+}
+
+func handleGetAddr(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(getaddr)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	limit := req.Max
+	if limit <= 0 || limit > maxAddrSize {
+		limit = maxAddrSize
+	}
+	list, err := knownAddrs(db, limit-1)
+	if err != nil {
+		return err
+	}
+	list = append(list, nodeAddr{Addr: nodeAddress, LastSeen: time.Now().Unix()})
+	return p.send("addr", addr{AddrList: list})
+}
+
+func handleAddr(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(addr)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	if len(req.AddrList) > maxAddrSize {
+		return errors.New("TOO MANY ADDRESSES")
+	}
+	fresh, err := storeAddrs(db, req.AddrList)
+	if err != nil {
+		return err
+	}
+	if len(fresh) > 0 {
+		go relayAddrs(fresh)
+	}
+	return nil
+}
+
+// answers light client with transactions of its keys and their merkle proofs
+func handleGetProofs(p *peer, request []byte, bc *blockchain.Blockchain, db *database.DB) error {
+	req := new(getproofs)
+	err := decodePayload(request, req)
+	if err != nil {
+		return err
+	}
+	if len(req.PubKeyHashes) > maxInvSize {
+		return errors.New("TOO MANY KEYS")
+	}
+	proofs, err := bc.FindTransactionProofs(req.PubKeyHashes)
+	if err != nil {
+		return err
+	}
+	resp := proofsMsg{Proofs: make([]txProof, len(proofs))}
+	for i, proof := range proofs {
+		serialized, err := proof.Transaction.Serialize()
+		if err != nil {
+			return err
+		}
+		resp.Proofs[i] = txProof{
+			Transaction: serialized,
+			BlockHash:   proof.BlockHash,
+			Index:       proof.Proof.Index,
+			Siblings:    proof.Proof.Siblings,
+		}
 	}
-	io.Copy(conn, response)
+	return p.send("proofs", resp)
 }
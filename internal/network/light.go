@@ -0,0 +1,82 @@
+package network
+
+import (
+	"bchain/internal/blockchain"
+)
+
+// downloads headers from the full node and proofs of transactions of the keys,
+// proofs are checked against the headers before wallet is updated
+func SyncLight(addr string, hc *blockchain.HeaderChain, pubKeyHashes [][]byte) error {
+	magic = hc.Params().Magic
+	if addr == "" {
+		addr = "localhost:" + hc.Params().DefaultPort
+	}
+	p, err := connectLightPeer(addr)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	locator, err := hc.GetBlockLocator()
+	if err != nil {
+		return err
+	}
+	for {
+		resp := new(headersMsg)
+		err = p.request("getheaders", getheaders{BlockLocatorHashes: locator}, "headers", resp)
+		if err != nil {
+			return err
+		}
+		headers := make([]blockchain.BlockHeader, len(resp.Headers))
+		for i, serialized := range resp.Headers {
+			header, err := blockchain.DeserializeHeader(serialized)
+			if err != nil {
+				return err
+			}
+			headers[i] = *header
+		}
+		err = hc.AddHeaders(headers)
+		if err != nil {
+			return err
+		}
+		if len(headers) < maxHeadersSize {
+			break
+		}
+		locator = [][]byte{headers[len(headers)-1].Hash}
+	}
+	resp := new(proofsMsg)
+	err = p.request("getproofs", getproofs{PubKeyHashes: pubKeyHashes}, "proofs", resp)
+	if err != nil {
+		return err
+	}
+	proofs := make([]blockchain.TransactionProof, len(resp.Proofs))
+	for i, item := range resp.Proofs {
+		tx, err := blockchain.DeserializeTransaction(item.Transaction)
+		if err != nil {
+			return err
+		}
+		proofs[i] = blockchain.TransactionProof{
+			Transaction: tx,
+			BlockHash:   item.BlockHash,
+			Proof:       &blockchain.MerkleProof{Index: item.Index, Siblings: item.Siblings},
+		}
+	}
+	return hc.UpdateWallet(proofs)
+}
+
+// passes transaction of the light client to the full node
+func SendTransactionLight(addr string, tx *blockchain.Transaction, hc *blockchain.HeaderChain) error {
+	magic = hc.Params().Magic
+	if addr == "" {
+		addr = "localhost:" + hc.Params().DefaultPort
+	}
+	serialized, err := tx.Serialize()
+	if err != nil {
+		return err
+	}
+	p, err := connectLightPeer(addr)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	return p.send("tx", txMsg{Transaction: serialized})
+}
@@ -0,0 +1,62 @@
+package network
+
+import (
+	"bchain/internal/blockchain"
+	database "bchain/internal/db"
+	"fmt"
+	"time"
+)
+
+// how often miner checks mempool for new transactions
+const mineInterval = time.Second
+
+// announces transaction to every peer except the one it was received from
+func relayTransaction(id []byte, from *peer) {
+	for _, p := range connectedPeers() {
+		if p == from {
+			continue
+		}
+		err := p.send("inv", inv{Type: invTypeTx, Inventory: [][]byte{id}})
+		if err != nil {
+			fmt.Printf("inv to %s: %s\n", p.Addr(), err)
+		}
+	}
+}
+
+// mines a block with valid mempool transactions whenever there are some, invalid ones are evicted
+func mineLoop(bc *blockchain.Blockchain, minerAddress string) {
+	for {
+		time.Sleep(mineInterval)
+		if bc.Mempool().Size() == 0 {
+			continue
+		}
+		block, err := bc.MineMempool(minerAddress)
+		if err != nil {
+			fmt.Printf("mining: %s\n", err)
+			continue
+		}
+		if block == nil {
+			continue
+		}
+		fmt.Printf("mined block %x with %d transactions\n", block.Hash, len(block.Transactions)-1)
+		announceBlock(block.Hash)
+	}
+}
+
+// connects to the node and passes transaction to it
+func SendTransaction(addr string, tx *blockchain.Transaction, bc *blockchain.Blockchain, db *database.DB) error {
+	magic = bc.Params().Magic
+	if addr == "" {
+		addr = "localhost:" + bc.Params().DefaultPort
+	}
+	serialized, err := tx.Serialize()
+	if err != nil {
+		return err
+	}
+	p, err := connectPeer(addr, bc, db)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	return p.send("tx", txMsg{Transaction: serialized})
+}
@@ -4,7 +4,10 @@ import (
 	"bchain/internal/blockchain"
 	database "bchain/internal/db"
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -12,19 +15,66 @@ import (
 )
 
 const (
-	commandLen = 12
-	protocol   = "tcp"
-	port       = "13334"
+	protocolVersion int32 = 6
+	// peers with lower protocol version are disconnected
+	minProtocolVersion int32 = 6
+)
+
+const (
+	commandLen  = 12
+	checksumLen = 4
+	// magic, command, payload length and payload checksum
+	headerLen      = 4 + commandLen + 4 + checksumLen
+	maxPayloadSize = 32 << 20
+	protocol       = "tcp"
+	maxInvSize     = 500
+	maxHeadersSize = 2000
+	// headers downloaded by one sync, longer chains are continued by the next one
+	maxSyncHeaders = 25 * maxHeadersSize
+	// blocks downloaded during sync before they are connected, bounds memory used by the sync
+	blockDownloadWindow = 128
+	syncInterval        = 10 * time.Second
+	// how long to wait for a response or for a message to be written
+	requestTimeout = 30 * time.Second
+)
+
+const (
+	invTypeBlock = "block"
+	invTypeTx    = "tx"
 )
 
 var (
-	dns = []string{
-		"localhost:13335",
-	}
+	// marks the start of every message, set from chain params of the node
+	magic = blockchain.MainnetParams.Magic
+	// address advertised to other nodes
+	nodeAddress string
 )
 
-func StartServer(db *database.DB, bc *blockchain.Blockchain) {
-	listener, err := net.Listen(protocol, ":"+port)
+type Config struct {
+	// port to listen on, default port of the network if empty
+	Port string
+	// address other nodes can reach this one at, localhost:Port if empty
+	Address string
+	// nodes used to join the network, seeds of the network if empty
+	Seeds []string
+	// address which receives rewards for mined blocks, node does not mine if empty
+	MinerAddress string
+}
+
+func StartServer(db *database.DB, bc *blockchain.Blockchain, cfg Config) {
+	magic = bc.Params().Magic
+	if cfg.Port == "" {
+		cfg.Port = bc.Params().DefaultPort
+	}
+	nodeAddress = cfg.Address
+	if nodeAddress == "" {
+		nodeAddress = "localhost:" + cfg.Port
+	}
+	seeds = cfg.Seeds
+	if len(seeds) == 0 {
+		seeds = bc.Params().Seeds
+	}
+	listener, err := net.Listen(protocol, ":"+cfg.Port)
 	if err != nil {
 		panic(err)
 	}
@@ -38,86 +88,135 @@ func StartServer(db *database.DB, bc *blockchain.Blockchain) {
 		if err != nil {
 			panic(err)
 		}
-		db.UpdateVersion(blockchain.BlockchainVersion)
+		err = db.UpdateVersion(blockchain.BlockchainVersion)
 		if err != nil {
 			panic(err)
 		}
 	}
+	go syncLoop(bc, db)
+	if cfg.MinerAddress != "" {
+		go mineLoop(bc, cfg.MinerAddress)
+	}
 	fmt.Println("waiting for conn")
 	for {
 		conn, err := listener.Accept()
-		fmt.Println("conn accepted")
 		if err != nil {
 			continue
 		}
-		fmt.Println("conn handled")
+		fmt.Printf("conn accepted from %s\n", conn.RemoteAddr())
 		go handleConn(conn, bc, db)
 	}
 }
 
+// serves inbound connection until it is closed by any side
 func handleConn(conn net.Conn, bc *blockchain.Blockchain, db *database.DB) {
-	defer conn.Close()
-	request, err := io.ReadAll(conn)
-	if err != nil {
-		fmt.Println(err)
-		// return
-	}
-	comm := commandFromBytes(request)
-	fmt.Printf("parsed command %s_%d\n", comm, len(comm))
-	if handler, ok := handlers[comm]; ok {
-		handler(conn, request, bc, db)
-	} else {
-		fmt.Println("Uknown command")
-	}
+	p := newPeer(conn, "")
+	addPeer(p)
+	p.run(bc, db)
 }
 
-func commandToBytes(command string) []byte {
+func commandToBytes(command string) ([]byte, error) {
 	var res [commandLen]byte
-	if len([]byte(command)) > commandLen {
-		panic("INCORRECT COMMAND LENGTH")
+	if len(command) == 0 || len(command) > commandLen {
+		return nil, errors.New("INCORRECT COMMAND LENGTH")
 	}
-	for i, b := range []byte(command) {
-		res[i] = b
-	}
-	return res[:]
+	copy(res[:], command)
+	return res[:], nil
 }
 
-func commandFromBytes(data []byte) string {
-	command := [commandLen]byte{}
-	i := 0
-	for _, b := range data[:12] {
-		if b != 0 {
-			command[i] = b
-			i++
+// command is printable ascii padded with zeros, anything else comes from a broken or hostile peer
+func commandFromBytes(data []byte) (string, error) {
+	data = data[:commandLen]
+	n := bytes.IndexByte(data, 0)
+	if n < 0 {
+		n = commandLen
+	}
+	if n == 0 {
+		return "", errors.New("EMPTY COMMAND")
+	}
+	for i, b := range data {
+		if i < n && (b <= ' ' || b > '~') || i >= n && b != 0 {
+			return "", errors.New("INVALID COMMAND")
 		}
 	}
-	fmt.Println(i)
-	fmt.Println(len(string(command[:i])))
-	return string(command[:i])
+	return string(data[:n]), nil
+}
+
+func payloadChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:checksumLen]
 }
 
-func SendVersion(addr string, bc *blockchain.Blockchain) {
-	conn, err := net.Dial(protocol, addr)
+// writes header followed by gob encoded payload. blocks, transactions and headers inside
+// payloads use the canonical encoding of the blockchain package, the envelope is Go specific
+func writeMessage(w io.Writer, command string, payload any) error {
+	encoded := new(bytes.Buffer)
+	encoder := gob.NewEncoder(encoded)
+	err := encoder.Encode(payload)
 	if err != nil {
-		return
+		return err
 	}
+	if encoded.Len() > maxPayloadSize {
+		return errors.New("TOO BIG PAYLOAD")
+	}
+	commandBytes, err := commandToBytes(command)
+	if err != nil {
+		return err
+	}
+	message := new(bytes.Buffer)
+	binary.Write(message, binary.LittleEndian, magic)
+	message.Write(commandBytes)
+	binary.Write(message, binary.LittleEndian, uint32(encoded.Len()))
+	message.Write(payloadChecksum(encoded.Bytes()))
+	message.Write(encoded.Bytes())
+	_, err = w.Write(message.Bytes())
+	return err
+}
+
+// reads one message and returns its command and payload
+func readMessage(r io.Reader) (string, []byte, error) {
+	header := make([]byte, headerLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return "", nil, err
+	}
+	if binary.LittleEndian.Uint32(header[:4]) != magic {
+		return "", nil, errors.New("INVALID MAGIC")
+	}
+	command, err := commandFromBytes(header[4:])
+	if err != nil {
+		return "", nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[4+commandLen:])
+	if length > maxPayloadSize {
+		return "", nil, errors.New("TOO BIG PAYLOAD")
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(payloadChecksum(payload), header[headerLen-checksumLen:]) {
+		return "", nil, errors.New("INVALID CHECKSUM")
+	}
+	return command, payload, nil
+}
+
+func decodePayload(payload []byte, v any) error {
+	decoder := gob.NewDecoder(bytes.NewReader(payload))
+	return decoder.Decode(v)
+}
+
+func newVersion(bc *blockchain.Blockchain) version {
 	h, err := bc.GetBestHeight()
 	if err != nil {
-		return
+		h = 0
 	}
-	resp := version{
-		Version:   blockchain.BlockchainVersion,
+	return version{
+		Version:   protocolVersion,
 		Height:    h,
 		Timestamp: time.Now().Unix(),
-		Addr:      conn.RemoteAddr().String(),
-	}
-	response := new(bytes.Buffer)
-	encoder := gob.NewEncoder(response)
-	response.Write(commandToBytes("version"))
-	err = encoder.Encode(&resp)
-	if err != nil {
-		return
+		Addr:      nodeAddress,
 	}
-	io.Copy(conn, response)
-	// todo: recieve version
 }
@@ -0,0 +1,36 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMessageCommand(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := writeMessage(buf, "getheaders", getaddr{Max: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, _, err := readMessage(bytes.NewReader(buf.Bytes()))
+	if err != nil || command != "getheaders" {
+		t.Fatalf("got %q %v", command, err)
+	}
+	for _, command := range []string{"", "commandistoolong"} {
+		if writeMessage(new(bytes.Buffer), command, getaddr{}) == nil {
+			t.Errorf("command %q is written", command)
+		}
+	}
+
+	valid := buf.Bytes()
+	for name, command := range map[string][]byte{
+		"empty":           make([]byte, commandLen),
+		"not printable":   append([]byte("get\x01addr"), make([]byte, 4)...),
+		"data after zero": append([]byte("inv\x00tx"), make([]byte, 7)...),
+	} {
+		message := append([]byte{}, valid...)
+		copy(message[4:4+commandLen], command)
+		if _, _, err := readMessage(bytes.NewReader(message)); err == nil {
+			t.Errorf("%s command is read", name)
+		}
+	}
+}
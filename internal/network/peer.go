@@ -0,0 +1,325 @@
+package network
+
+import (
+	"bchain/internal/blockchain"
+	database "bchain/internal/db"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// long-lived connection with another node
+type peer struct {
+	conn net.Conn
+	// guards addr, height and handshake state
+	mu sync.Mutex
+	// listening address, for inbound connections it is known after version message
+	addr   string
+	height uint64
+	// connection of a light client to a full node, it only makes requests
+	light bool
+	// protocol version used with the peer, the lowest of both nodes
+	protoVersion    int32
+	versionSent     bool
+	versionReceived bool
+	verackReceived  bool
+	// closed when both sides received version and verack
+	handshakeDone chan struct{}
+	// one message is written at a time
+	writeMu sync.Mutex
+	// one request is waiting for response at a time
+	reqMu sync.Mutex
+	// responses expected by request, keyed by command and requested item
+	waitMu  sync.Mutex
+	waiting map[string]chan response
+	// closed when connection is closed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	peersMu sync.Mutex
+	peers   = map[*peer]bool{}
+)
+
+func newPeer(conn net.Conn, addr string) *peer {
+	return &peer{
+		conn:          conn,
+		addr:          addr,
+		handshakeDone: make(chan struct{}),
+		waiting:       map[string]chan response{},
+		done:          make(chan struct{}),
+	}
+}
+
+// dials node, makes handshake and starts serving the connection
+func connectPeer(addr string, bc *blockchain.Blockchain, db *database.DB) (*peer, error) {
+	conn, err := net.DialTimeout(protocol, addr, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := newPeer(conn, addr)
+	addPeer(p)
+	go p.run(bc, db)
+	err = p.sendVersion(bc)
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return waitHandshake(p)
+}
+
+// dials full node from a light client. light client reports zero height
+// because it serves no blocks, messages other than responses are ignored
+func connectLightPeer(addr string) (*peer, error) {
+	conn, err := net.DialTimeout(protocol, addr, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := newPeer(conn, addr)
+	p.light = true
+	p.versionSent = true
+	go p.run(nil, nil)
+	err = p.send("version", version{Version: protocolVersion, Timestamp: time.Now().Unix()})
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return waitHandshake(p)
+}
+
+func waitHandshake(p *peer) (*peer, error) {
+	select {
+	case <-p.handshakeDone:
+		return p, nil
+	case <-p.done:
+		return nil, errors.New("PEER DISCONNECTED DURING HANDSHAKE")
+	case <-time.After(requestTimeout):
+		p.close()
+		return nil, errors.New("HANDSHAKE TIMEOUT")
+	}
+}
+
+func addPeer(p *peer) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	peers[p] = true
+}
+
+func removePeer(p *peer) {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	delete(peers, p)
+}
+
+// returns peers which completed the handshake and reported their listening address
+func connectedPeers() []*peer {
+	peersMu.Lock()
+	defer peersMu.Unlock()
+	list := []*peer{}
+	for p := range peers {
+		if p.isHandshakeDone() && p.Addr() != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func isConnected(addr string) bool {
+	for _, p := range connectedPeers() {
+		if p.Addr() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *peer) Addr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addr
+}
+
+func (p *peer) setAddr(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addr = addr
+}
+
+func (p *peer) Height() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.height
+}
+
+func (p *peer) setHeight(height uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.height = height
+}
+
+func (p *peer) isHandshakeDone() bool {
+	select {
+	case <-p.handshakeDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *peer) sendVersion(bc *blockchain.Blockchain) error {
+	p.mu.Lock()
+	p.versionSent = true
+	p.mu.Unlock()
+	return p.send("version", newVersion(bc))
+}
+
+// marks handshake done if both version and verack are received, returns true only once
+func (p *peer) completeHandshake() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.versionReceived || !p.verackReceived || p.isHandshakeDone() {
+		return false
+	}
+	close(p.handshakeDone)
+	return true
+}
+
+// reads messages until connection is closed, responses are passed to the waiting
+// requests and everything else to the handlers
+func (p *peer) run(bc *blockchain.Blockchain, db *database.DB) {
+	defer p.close()
+	for {
+		command, payload, err := readMessage(p.conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fmt.Printf("read from %s: %s\n", p.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if !p.isHandshakeDone() && command != "version" && command != "verack" {
+			fmt.Printf("%s from %s before handshake, disconnecting\n", command, p.conn.RemoteAddr())
+			return
+		}
+		if p.deliver(command, payload) {
+			continue
+		}
+		if p.light && command != "version" && command != "verack" {
+			continue
+		}
+		handler, ok := handlers[command]
+		if !ok {
+			fmt.Printf("Uknown command %s\n", command)
+			continue
+		}
+		if err := handler(p, payload, bc, db); err != nil {
+			fmt.Printf("%s from %s: %s\n", command, p.conn.RemoteAddr(), err)
+		}
+	}
+}
+
+func (p *peer) send(command string, payload any) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	return writeMessage(p.conn, command, payload)
+}
+
+type response struct {
+	command string
+	payload []byte
+}
+
+// key of the waiting request, item is the hash of requested block or nil if any
+// message with the command is a response
+func waitKey(command string, item []byte) string {
+	return command + string(item)
+}
+
+// sends message and waits for the response with the key
+func (p *peer) request(command string, payload any, key string, resp any) error {
+	p.reqMu.Lock()
+	defer p.reqMu.Unlock()
+	ch := make(chan response, 1)
+	p.waitMu.Lock()
+	p.waiting[key] = ch
+	p.waitMu.Unlock()
+	defer func() {
+		p.waitMu.Lock()
+		delete(p.waiting, key)
+		p.waitMu.Unlock()
+	}()
+	err := p.send(command, payload)
+	if err != nil {
+		return err
+	}
+	select {
+	case r := <-ch:
+		if r.command == "notfound" {
+			return errors.New("ITEM IS NOT FOUND")
+		}
+		return decodePayload(r.payload, resp)
+	case <-p.done:
+		return errors.New("PEER DISCONNECTED")
+	case <-time.After(requestTimeout):
+		return errors.New("REQUEST TIMEOUT")
+	}
+}
+
+// returns keys of the requests the message can answer
+func responseKeys(command string, payload []byte) []string {
+	switch command {
+	case "block":
+		msg := new(block)
+		if decodePayload(payload, msg) != nil || len(msg.Block) < 1+blockchain.HeaderSize {
+			return nil
+		}
+		// serialized block starts with version byte followed by the header
+		header, err := blockchain.DeserializeHeader(msg.Block[1 : 1+blockchain.HeaderSize])
+		if err != nil {
+			return nil
+		}
+		return []string{waitKey(command, header.Hash)}
+	case "notfound":
+		msg := new(notfound)
+		if decodePayload(payload, msg) != nil || msg.Type != invTypeBlock {
+			return nil
+		}
+		keys := make([]string, len(msg.Inventory))
+		for i, hash := range msg.Inventory {
+			keys[i] = waitKey("block", hash)
+		}
+		return keys
+	default:
+		return []string{waitKey(command, nil)}
+	}
+}
+
+// passes message to the waiting requests, returns false if nobody waits for it
+func (p *peer) deliver(command string, payload []byte) bool {
+	delivered := false
+	for _, key := range responseKeys(command, payload) {
+		p.waitMu.Lock()
+		ch, ok := p.waiting[key]
+		if ok {
+			delete(p.waiting, key)
+		}
+		p.waitMu.Unlock()
+		if ok {
+			ch <- response{command: command, payload: payload}
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+func (p *peer) close() {
+	p.closeOnce.Do(func() {
+		p.conn.Close()
+		close(p.done)
+		removePeer(p)
+	})
+}
@@ -1,14 +1,31 @@
 package network
 
 type version struct {
+	// protocol version
 	Version   int32
 	Height    uint64
 	Timestamp int64
-	Addr      string
+	// listening address of the sender
+	Addr string
+}
+
+type verack struct {
+	// protocol version the peer agreed to use
+	Version int32
 }
 
 type addr struct {
-	AddrList []string
+	AddrList []nodeAddr
+}
+
+type nodeAddr struct {
+	Addr     string
+	LastSeen int64
+}
+
+type getaddr struct {
+	// maximum number of addresses in the response
+	Max int
 }
 
 type inv struct {
@@ -17,15 +34,53 @@ type inv struct {
 }
 
 type getdata struct {
+	Type      string
+	Inventory [][]byte
+}
+
+// items from getdata which the node doesn't have
+type notfound struct {
+	Type      string
 	Inventory [][]byte
 }
 
 type getblocks struct {
+	// hash to stop at, empty to get as many blocks as possible
 	LastHash           []byte
-	BlockLocatorHashes []uint64
+	BlockLocatorHashes [][]byte
 }
 
 type getheaders struct {
-	BlockLocatorHashes []uint64
+	BlockLocatorHashes [][]byte
 	LastHash           []byte
 }
+
+type block struct {
+	Block []byte
+}
+
+type txMsg struct {
+	Transaction []byte
+}
+
+// serialized headers
+type headersMsg struct {
+	Headers [][]byte
+}
+
+// requests transactions relevant to light client wallet
+type getproofs struct {
+	PubKeyHashes [][]byte
+}
+
+// serialized transaction with merkle proof that it is in the block
+type txProof struct {
+	Transaction []byte
+	BlockHash   []byte
+	Index       uint64
+	Siblings    [][]byte
+}
+
+type proofsMsg struct {
+	Proofs []txProof
+}
@@ -0,0 +1,235 @@
+package network
+
+import (
+	"bchain/internal/blockchain"
+	database "bchain/internal/db"
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// prevents running several synchronizations at the same time
+var syncMu sync.Mutex
+
+// checks if node with peerHeight has blocks which this node does not have
+func needsSync(bc *blockchain.Blockchain, peerHeight uint64) bool {
+	if bc.IsEmpty() {
+		return true
+	}
+	h, err := bc.GetBestHeight()
+	return err == nil && peerHeight > h
+}
+
+func syncLoop(bc *blockchain.Blockchain, db *database.DB) {
+	var announced []byte
+	for {
+		for _, node := range selectPeers(db) {
+			p, err := connectPeer(node, bc, db)
+			if err != nil {
+				fmt.Printf("handshake with %s: %s\n", node, err)
+				continue
+			}
+			err = requestAddrs(p, db)
+			if err != nil {
+				fmt.Printf("getaddr from %s: %s\n", node, err)
+			}
+		}
+		connected := connectedPeers()
+		for _, p := range connected {
+			if needsSync(bc, p.Height()) {
+				if err := syncWith(connected, bc); err != nil {
+					fmt.Printf("sync: %s\n", err)
+				}
+				break
+			}
+		}
+		// chain can be extended by mining or by another process using the same db
+		tip, err := bc.GetLastHash()
+		if err == nil && len(tip) > 0 && !bytes.Equal(tip, announced) {
+			announceBlock(tip)
+			announced = tip
+		}
+		time.Sleep(syncInterval)
+	}
+}
+
+func announceBlock(hash []byte) {
+	for _, p := range connectedPeers() {
+		err := p.send("inv", inv{Type: invTypeBlock, Inventory: [][]byte{hash}})
+		if err != nil {
+			fmt.Printf("inv to %s: %s\n", p.Addr(), err)
+		}
+	}
+}
+
+// downloads and validates headers from the highest peer, then downloads block bodies
+// in parallel from every peer which has them. bodies are downloaded in windows which
+// are connected in height order before the next one is requested
+func syncWith(peers []*peer, bc *blockchain.Blockchain) error {
+	if !syncMu.TryLock() {
+		return nil
+	}
+	defer syncMu.Unlock()
+	if len(peers) == 0 {
+		return nil
+	}
+	best := peers[0]
+	for _, p := range peers[1:] {
+		if p.Height() > best.Height() {
+			best = p
+		}
+	}
+	headers, err := downloadHeaders(best, bc)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	last := headers[len(headers)-1].Height
+	if best.Height() < last {
+		best.setHeight(last)
+	}
+	sources := []*peer{best}
+	for _, p := range peers {
+		if p != best && p.Height() >= last {
+			sources = append(sources, p)
+		}
+	}
+	for len(headers) > 0 {
+		window := headers
+		if len(window) > blockDownloadWindow {
+			window = window[:blockDownloadWindow]
+		}
+		headers = headers[len(window):]
+		blocks, err := downloadBlocks(sources, window)
+		if err != nil {
+			return err
+		}
+		for _, header := range window {
+			err = bc.AddBlock(blocks[string(header.Hash)])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func downloadHeaders(p *peer, bc *blockchain.Blockchain) ([]blockchain.BlockHeader, error) {
+	headers := []blockchain.BlockHeader{}
+	locator := bc.GetBlockLocator()
+	var prev *blockchain.BlockHeader
+	for {
+		resp := new(headersMsg)
+		err := p.request("getheaders", getheaders{BlockLocatorHashes: locator}, "headers", resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Headers) == 0 {
+			break
+		}
+		received := make([]blockchain.BlockHeader, len(resp.Headers))
+		for i, serialized := range resp.Headers {
+			header, err := blockchain.DeserializeHeader(serialized)
+			if err != nil {
+				return nil, err
+			}
+			received[i] = *header
+		}
+		err = bc.ValidateHeaders(prev, received)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, received...)
+		prev = &headers[len(headers)-1]
+		if len(received) < maxHeadersSize || len(headers) >= maxSyncHeaders {
+			break
+		}
+		locator = [][]byte{prev.Hash}
+	}
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	// bodies are requested only for a chain with more work, proof of work of its headers
+	// is already checked, so a bogus chain costs its sender as much as the local one
+	more, err := bc.HasMoreWork(headers)
+	if err != nil {
+		return nil, err
+	}
+	if !more {
+		return nil, errors.New("HEADER CHAIN DOES NOT HAVE MORE WORK THAN LOCAL CHAIN")
+	}
+	missing := []blockchain.BlockHeader{}
+	for _, header := range headers {
+		if !bc.HasBlock(header.Hash) {
+			missing = append(missing, header)
+		}
+	}
+	return missing, nil
+}
+
+// distributes block requests between peers, blocks which were not received
+// from a failed peer are requested again from the other ones
+func downloadBlocks(sources []*peer, headers []blockchain.BlockHeader) (map[string]*blockchain.Block, error) {
+	blocks := make(map[string]*blockchain.Block, len(headers))
+	mu := sync.Mutex{}
+	jobs := make(chan []byte, len(headers))
+	for _, header := range headers {
+		jobs <- header.Hash
+	}
+	close(jobs)
+	wg := sync.WaitGroup{}
+	for _, p := range sources {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			for hash := range jobs {
+				b, err := fetchBlock(p, hash)
+				if err != nil {
+					fmt.Printf("getdata from %s: %s\n", p.Addr(), err)
+					return
+				}
+				mu.Lock()
+				blocks[string(hash)] = b
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	for _, header := range headers {
+		if _, ok := blocks[string(header.Hash)]; ok {
+			continue
+		}
+		for _, p := range sources {
+			b, err := fetchBlock(p, header.Hash)
+			if err == nil {
+				blocks[string(header.Hash)] = b
+				break
+			}
+		}
+		if _, ok := blocks[string(header.Hash)]; !ok {
+			return nil, errors.New("CANNOT DOWNLOAD BLOCK")
+		}
+	}
+	return blocks, nil
+}
+
+func fetchBlock(p *peer, hash []byte) (*blockchain.Block, error) {
+	resp := new(block)
+	req := getdata{Type: invTypeBlock, Inventory: [][]byte{hash}}
+	err := p.request("getdata", req, waitKey("block", hash), resp)
+	if err != nil {
+		return nil, err
+	}
+	b, err := blockchain.DeserializeBlock(resp.Block)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(b.Hash, hash) {
+		return nil, errors.New("RECEIVED UNREQUESTED BLOCK")
+	}
+	return b, nil
+}